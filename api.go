@@ -1,23 +1,21 @@
 package stdapp
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
+	"net/http"
 	"time"
 
 	"go.ddollar.dev/coalesce"
 	"go.ddollar.dev/errors"
 	"go.ddollar.dev/graphql-transport-ws/graphqlws"
 	"go.ddollar.dev/stdapi"
-	"go.ddollar.dev/stdgraph"
-	"github.com/uptrace/bun"
-	"github.com/uptrace/bun/dialect/pgdialect"
-	"github.com/uptrace/bun/driver/pgdriver"
 )
 
 type API struct {
 	app    *App
 	server *stdapi.Server
+	stop   context.CancelFunc
 }
 
 func (a *App) api() (*API, error) {
@@ -26,19 +24,51 @@ func (a *App) api() (*API, error) {
 		server: stdapi.New("api", a.opts.Name),
 	}
 
-	if err := api.handleGraphQL(a); err != nil {
+	if err := api.handle(); err != nil {
 		return nil, errors.Wrap(err)
 	}
 
-	if err := api.handleRouter(a); err != nil {
+	return api, nil
+}
+
+func (a *App) Handler() (http.Handler, error) {
+	api, err := a.api()
+	if err != nil {
 		return nil, errors.Wrap(err)
 	}
 
-	return api, nil
+	if api.server.Wrapper != nil {
+		return api.server.Wrapper(api.server), nil
+	}
+
+	return api.server, nil
+}
+
+func (a *API) Close() error {
+	if a.stop != nil {
+		a.stop()
+	}
+
+	return a.app.Close()
+}
+
+func (a *API) handle() error {
+	a.app.handleChecks(a.server)
+	a.app.handleStats(a.server)
+
+	if err := a.handleGraphQL(a.app); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := a.handleRouter(a.app); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
 }
 
 func (a *API) handleGraphQL(app *App) error {
-	if app.opts.Resolver == nil {
+	if !app.hasResolver() {
 		return nil
 	}
 
@@ -46,32 +76,84 @@ func (a *API) handleGraphQL(app *App) error {
 		graphqlws.WithWriteTimeout(coalesce.Any(app.opts.WriteTimeout, 10*time.Second)),
 	}
 
-	for _, domain := range app.domains() {
-		sdb := sql.OpenDB(pgdriver.NewConnector(
-			pgdriver.WithDSN(app.opts.Database),
-			pgdriver.WithConnParams(map[string]interface{}{
-				"search_path": domain,
-			}),
-		))
+	dr := &domainRouter{app: app, endpoints: map[string]*domainEndpoint{}, options: gopts, static: map[string]*domainEndpoint{}}
 
-		db := bun.NewDB(sdb, pgdialect.New())
+	for _, domain := range app.staticDomains() {
+		if app.domainConfig(domain).Resolver == nil {
+			continue
+		}
 
-		r, err := app.opts.Resolver(db, domain)
+		e, err := app.domainEndpoint(domain, gopts...)
 		if err != nil {
 			return errors.Wrap(err)
 		}
 
-		h, err := stdgraph.NewHandler(r.Schema(), r, gopts...)
-		if err != nil {
-			return errors.Wrap(err)
+		dr.static[domain] = e
+	}
+
+	if app.opts.DomainResolver != nil {
+		endpoint := fmt.Sprintf("%s/api/graph", app.opts.Prefix)
+		h := app.WithMiddleware(domainResolver{router: dr})
+
+		a.server.Router.Handle(endpoint, h)
+		a.server.Router.PathPrefix(endpoint + "/").Handler(h)
+	}
+
+	for _, domain := range app.staticDomains() {
+		e, ok := dr.static[domain]
+		if !ok {
+			continue
 		}
 
-		a.server.Router.PathPrefix(fmt.Sprintf("%s/api/%s", app.opts.Prefix, domain)).Handler(app.WithMiddleware(h))
+		endpoint := fmt.Sprintf("%s/api/%s", app.opts.Prefix, domain)
+
+		if e.graphiql != nil {
+			a.server.Router.Handle(endpoint+"/graphiql", app.WithMiddleware(e.graphiql)).Methods("GET")
+		}
+
+		a.server.Router.PathPrefix(endpoint).Handler(app.WithMiddleware(e.graph))
+	}
+
+	if app.opts.DynamicDomains {
+		a.handleDomains(app, dr)
 	}
 
 	return nil
 }
 
+// domainEndpoint builds a domain's handlers, wrapped in the domain's own
+// middleware but not in Options.Middleware
+func (a *App) domainEndpoint(domain string, opts ...graphqlws.Option) (*domainEndpoint, error) {
+	c := a.domainConfig(domain)
+
+	if c.Resolver == nil {
+		return nil, errors.Errorf("no resolver configured for domain: %s", domain)
+	}
+
+	db, err := a.DB(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	r, err := c.Resolver(db, domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	g, err := a.graph(domain, r, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	e := &domainEndpoint{graph: wrapMiddleware(g.ServeHTTP, c.Middleware)}
+
+	if c.Features[FeatureGraphiQL] {
+		e.graphiql = wrapMiddleware(a.graphiql(domain, fmt.Sprintf("%s/api/%s", a.opts.Prefix, domain)), c.Middleware)
+	}
+
+	return e, nil
+}
+
 func (a *API) handleRouter(app *App) error {
 	if app.opts.Router == nil {
 		return nil
@@ -1,49 +1,137 @@
 package stdapp
 
 import (
+	"database/sql"
 	"fmt"
 	"io/fs"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
-	"go.ddollar.dev/coalesce"
+	"github.com/uptrace/bun"
 	"go.ddollar.dev/errors"
 	"go.ddollar.dev/logger"
 	"go.ddollar.dev/stdcli"
+	"go.ddollar.dev/stdgraph"
 )
 
 var version = "dev"
 
 var (
+	flagCert        = stdcli.StringFlag("cert", "", "tls certificate file")
+	flagCertCache   = stdcli.StringFlag("cert-cache", "", "directory in which to cache the generated development certificate")
 	flagDevelopment = stdcli.BoolFlag("development", "d", "run in development mode")
+	flagDomain      = stdcli.StringFlag("domain", "", "domain to operate on")
+	flagDrain       = stdcli.DurationFlag("drain", "", "time to wait for connections to drain on shutdown")
+	flagKey         = stdcli.StringFlag("key", "", "tls key file")
+	flagOutput      = stdcli.StringFlag("output", "o", "output format (json)")
+	flagProto       = stdcli.StringFlag("proto", "", "protocol to listen on (http, https, h2)")
 	flagWatch       = stdcli.StringFlag("watch", "w", "comma separated list of file extensions to watch in development mode")
 )
 
 type App struct {
-	logger *logger.Logger
-	opts   Options
+	dbs        map[string]*bun.DB
+	lock       sync.Mutex
+	logger     *logger.Logger
+	notifiers  map[string]*Notifier
+	opts       Options
+	persisted  *queries
+	registered []string
+	sources    map[string]string
+	sqldb      *sql.DB
 }
 
 type Options struct {
-	Compose      bool
-	Database     string
-	Domains      []string
-	Middleware   []Middleware
-	Migrations   fs.FS
-	Name         string
-	Prefix       string
-	Resolver     ResolverFunc
-	Router       RouterFunc
-	Web          fs.FS
-	WriteTimeout time.Duration
+	Anonymize         map[string]string
+	CertificateCache  string
+	CertificateFile   string
+	Checks            map[string]CheckFunc
+	Compose           bool
+	ConnMaxIdleTime   time.Duration
+	ConnMaxLifetime   time.Duration
+	Database          string
+	Development       bool
+	DomainConfig      map[string]DomainConfig
+	DomainResolver    DomainResolverFunc
+	Domains           []string
+	DynamicDomains    bool
+	FieldCosts        map[string]FieldCost
+	GraphiQL          bool
+	GraphQLMiddleware []stdgraph.MiddlewareFunc
+	Jobs              map[string]JobFunc
+	JobTimeout        time.Duration
+	KeyFile           string
+	MaxComplexity     int
+	MaxDepth          int
+	MaxIdleConns      int
+	MaxOpenConns      int
+	Middleware        []Middleware
+	Migrations        fs.FS
+	Name              string
+	OnReady           HookFunc
+	OnShutdown        ShutdownFunc
+	OnStart           HookFunc
+	OperationTimeout  time.Duration
+	PersistedQueries  string
+	Port              int
+	Prefix            string
+	Protocol          string
+	QueryAllowList    string
+	RedactVariables   []string
+	Resolver          ResolverFunc
+	RootSchema        string
+	Router            RouterFunc
+	Schedules         []Schedule
+	SchemaPrefix      string
+	ShutdownTimeout   time.Duration
+	SlowOperation     time.Duration
+	StatementTimeout  time.Duration
+	Web               fs.FS
+	WriteTimeout      time.Duration
+}
+
+// Options returns the options the app runs with, including those applied by
+// LoadConfig
+func (a *App) Options() Options {
+	return a.opts
+}
+
+// databaseCommands fail before they start when no database is configured
+var databaseCommands = []string{
+	"api",
+	"domain clone",
+	"domain create",
+	"domain drop",
+	"domain list",
+	"job dead",
+	"job retry",
+	"migrate",
+	"migrate status",
+	"pg console",
+	"pg export",
+	"pg import",
+	"pg reset",
+	"schedule list",
+	"scheduler",
+	"serve",
+	"worker",
 }
 
 func (a *App) Run(args []string) int {
 	c := stdcli.New(a.opts.Name, version)
 
+	c.Flags = []stdcli.Flag{flagConfig}
+
 	c.Command("api", "run the api server", a.cliApi, stdcli.CommandOptions{
 		Flags: []stdcli.Flag{
+			flagCert,
+			flagCertCache,
 			flagDevelopment,
+			flagDrain,
+			flagKey,
+			flagProto,
 			flagWatch,
 			stdcli.IntFlag("port", "p", "port to listen on"),
 		},
@@ -57,18 +145,70 @@ func (a *App) Run(args []string) int {
 		Validate: stdcli.ArgsMin(1),
 	})
 
+	c.Command("config", "show the effective configuration", a.cliConfig, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagOutput,
+		},
+	})
+
 	c.Command("deployment", "run a command on the deploy target", a.cliDeployment, stdcli.CommandOptions{})
 
+	c.Command("domain clone", "copy a domain's schema and data into a new domain", a.cliDomainClone, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			stdcli.BoolFlag("anonymize", "", "replace the columns in Options.Anonymize while copying"),
+		},
+		Usage:    "<src> <dst>",
+		Validate: stdcli.Args(2),
+	})
+
+	c.Command("domain create", "create a domain and run its migrations", a.cliDomainCreate, stdcli.CommandOptions{
+		Usage:    "<name>",
+		Validate: stdcli.Args(1),
+	})
+
+	c.Command("domain drop", "drop a domain and its schema", a.cliDomainDrop, stdcli.CommandOptions{
+		Usage:    "<name>",
+		Validate: stdcli.Args(1),
+	})
+
+	c.Command("domain list", "list domains", a.cliDomainList, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagOutput,
+		},
+	})
+
 	c.Command("init", "initialize a new project", a.cliInit, stdcli.CommandOptions{
 		Validate: stdcli.Args(1),
 	})
 
+	c.Command("job dead", "list jobs that have exhausted their attempts", a.cliJobDead, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagDomain,
+			flagOutput,
+		},
+	})
+
+	c.Command("job retry", "retry a dead job", a.cliJobRetry, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagDomain,
+		},
+		Usage:    "<id>",
+		Validate: stdcli.Args(1),
+	})
+
 	c.Command("migrate", "run migrations", a.cliMigrate, stdcli.CommandOptions{
 		Flags: []stdcli.Flag{
 			stdcli.BoolFlag("dry", "", "dry run"),
 		},
 	})
 
+	c.Command("migrate status", "show applied, pending and missing migrations for each domain", a.cliMigrateStatus, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagOutput,
+			stdcli.BoolFlag("check", "", "exit with an error if any migrations are pending or missing"),
+		},
+	})
+
 	c.Command("migration", "create a migration", a.cliMigration, stdcli.CommandOptions{
 		Flags: []stdcli.Flag{
 			stdcli.StringFlag("dir", "d", "dir in which to create migration"),
@@ -89,20 +229,79 @@ func (a *App) Run(args []string) int {
 
 	c.Command("pg reset", "reset databaser", a.cliPgReset, stdcli.CommandOptions{})
 
+	c.Command("queries extract", "write an allow-list of the operations in web sources", a.cliQueriesExtract, stdcli.CommandOptions{
+		Validate: stdcli.ArgsMin(1),
+	})
+
+	c.Command("schedule list", "list schedules with their next run and last result", a.cliScheduleList, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagOutput,
+		},
+	})
+
+	c.Command("scheduler", "run scheduled tasks", a.cliScheduler, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagDevelopment,
+			flagDrain,
+			flagWatch,
+		},
+	})
+
+	c.Command("schema check", "report breaking changes against a previous schema", a.cliSchemaCheck, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagDomain,
+			stdcli.StringFlag("against", "a", "previous schema file, or directory of <domain>.json or <domain>.graphql files"),
+		},
+	})
+
+	c.Command("schema dump", "write the sdl and introspection json for each domain", a.cliSchemaDump, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagDomain,
+			stdcli.StringFlag("dir", "d", "directory to write to (default schema)"),
+		},
+	})
+
+	c.Command("serve", "run the api and web servers on a single port", a.cliServe, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagCert,
+			flagCertCache,
+			flagDevelopment,
+			flagDrain,
+			flagKey,
+			flagProto,
+			flagWatch,
+			stdcli.IntFlag("port", "p", "port to listen on"),
+		},
+	})
+
 	c.Command("sleep", "sleep forever", a.cliSleep, stdcli.CommandOptions{})
 
+	c.Command("worker", "run background jobs", a.cliWorker, stdcli.CommandOptions{
+		Flags: []stdcli.Flag{
+			flagDevelopment,
+			flagDrain,
+			flagWatch,
+			stdcli.IntFlag("concurrency", "c", "number of jobs to run at once"),
+		},
+	})
+
 	c.Command("web", "start web server", a.cliWeb, stdcli.CommandOptions{
 		Flags: []stdcli.Flag{
+			flagCert,
+			flagCertCache,
 			flagDevelopment,
+			flagDrain,
+			flagKey,
+			flagProto,
 			stdcli.IntFlag("port", "p", "port to listen on"),
 		},
 	})
 
-	return c.Execute(args)
-}
+	for i := range c.Commands {
+		c.Commands[i].Handler = a.configure(c.Commands[i].Handler, slices.Contains(databaseCommands, strings.Join(c.Commands[i].Command, " ")))
+	}
 
-func (a *App) domains() []string {
-	return coalesce.Any(a.opts.Domains, []string{"public"})
+	return c.Execute(args)
 }
 
 func (a *App) run(container, command string, args ...string) error {
@@ -0,0 +1,139 @@
+package stdapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/stdapi"
+)
+
+const checkTimeout = 5 * time.Second
+
+type CheckFunc func(ctx context.Context) error
+
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type checkReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+func (a *App) handleChecks(s *stdapi.Server) {
+	s.Route("GET", "/check/live", a.checkLive)
+	s.Route("GET", "/check/ready", a.checkReady)
+}
+
+func (a *App) checkLive(c *stdapi.Context) error {
+	return renderCheck(c, checkReport{Status: "ok", Checks: []checkResult{}})
+}
+
+func (a *App) checkReady(c *stdapi.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context(), checkTimeout)
+	defer cancel()
+
+	return renderCheck(c, a.runChecks(ctx, a.readinessChecks()))
+}
+
+func (a *App) readinessChecks() map[string]CheckFunc {
+	checks := map[string]CheckFunc{}
+
+	if a.opts.Database != "" {
+		for _, domain := range a.domains() {
+			checks[fmt.Sprintf("database:%s", domain)] = a.checkDatabase(domain)
+			checks[fmt.Sprintf("migrations:%s", domain)] = a.checkMigrations(domain)
+		}
+	}
+
+	for name, fn := range a.opts.Checks {
+		checks[name] = fn
+	}
+
+	return checks
+}
+
+func (a *App) checkDatabase(domain string) CheckFunc {
+	return func(ctx context.Context) error {
+		db, err := a.DB(domain)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if err := db.PingContext(context.WithValue(ctx, contextSchema, a.Schema(domain))); err != nil {
+			return errors.Wrap(err)
+		}
+
+		return nil
+	}
+}
+
+func (a *App) checkMigrations(domain string) CheckFunc {
+	return func(ctx context.Context) error {
+		pending, err := a.pendingMigrations(ctx, domain)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if len(pending) > 0 {
+			return errors.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+		}
+
+		return nil
+	}
+}
+
+func (a *App) runChecks(ctx context.Context, checks map[string]CheckFunc) checkReport {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	report := checkReport{Status: "ok", Checks: []checkResult{}}
+
+	for name, fn := range checks {
+		wg.Add(1)
+
+		go func(name string, fn CheckFunc) {
+			defer wg.Done()
+
+			r := checkResult{Name: name, Status: "ok"}
+
+			if err := fn(ctx); err != nil {
+				r.Status = "error"
+				r.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if r.Status != "ok" {
+				report.Status = "error"
+			}
+
+			report.Checks = append(report.Checks, r)
+		}(name, fn)
+	}
+
+	wg.Wait()
+
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+
+	return report
+}
+
+func renderCheck(c *stdapi.Context, report checkReport) error {
+	c.Response().Header().Set("Content-Type", "application/json")
+
+	if report.Status != "ok" {
+		c.Response().WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	return c.RenderJSON(report)
+}
@@ -2,14 +2,15 @@ package stdapp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"io/fs"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -31,10 +32,9 @@ func (a *App) cliApi(ctx stdcli.Context) error {
 	if err != nil {
 		return errors.Wrap(err)
 	}
+	defer api.Close()
 
-	port := coalesce.Any(ctx.Flags().Int("port"), 8000)
-
-	if err := api.server.Listen("https", fmt.Sprintf(":%d", port)); err != nil {
+	if err := a.listen(ctx, api.server, a.listenOptions(ctx)); err != nil {
 		return errors.Wrap(err)
 	}
 
@@ -48,17 +48,29 @@ func (a *App) cliCmd(ctx stdcli.Context) error {
 		return a.watchAndReload(parseExtensions(ctx.Flags().String("watch")), "cmd", append([]string{"go", "run", fmt.Sprintf("./cmd/%s", args[0])}, args[1:]...)...)
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
+	return a.lifecycle(ctx, func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
-		return errors.Wrap(err)
+		if err := cmd.Run(); err != nil {
+			return errors.Wrap(err)
+		}
+
+		return nil
+	})
+}
+
+func (a *App) cliConfig(ctx stdcli.Context) error {
+	t := ctx.Table("KEY", "ENV", "VALUE", "SOURCE")
+
+	for _, v := range a.effectiveConfig() {
+		t.Append(v.Key, v.Env, v.Value, v.Source)
 	}
 
-	return nil
+	return t.Print()
 }
 
 func (a *App) cliDeployment(ctx stdcli.Context) error {
@@ -85,6 +97,79 @@ func (a *App) cliDeployment(ctx stdcli.Context) error {
 	return nil
 }
 
+func (a *App) cliDomainClone(ctx stdcli.Context) error {
+	anonymize := ctx.Flags().Bool("anonymize")
+
+	if a.opts.Compose {
+		args := []string{"run", ".", "domain", "clone", ctx.Arg(0), ctx.Arg(1)}
+
+		if anonymize {
+			args = append(args, "--anonymize")
+		}
+
+		return a.run("api", "go", args...)
+	}
+
+	defer a.Close()
+
+	if err := a.CloneDomain(ctx, ctx.Arg(0), ctx.Arg(1), CloneOptions{Anonymize: anonymize}); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) cliDomainCreate(ctx stdcli.Context) error {
+	if a.opts.Compose {
+		return a.run("api", "go", "run", ".", "domain", "create", ctx.Arg(0))
+	}
+
+	defer a.Close()
+
+	if err := a.CreateDomain(ctx, ctx.Arg(0)); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) cliDomainDrop(ctx stdcli.Context) error {
+	if a.opts.Compose {
+		return a.run("api", "go", "run", ".", "domain", "drop", ctx.Arg(0))
+	}
+
+	defer a.Close()
+
+	if err := a.DropDomain(ctx, ctx.Arg(0)); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) cliDomainList(ctx stdcli.Context) error {
+	t := ctx.Table("NAME", "SOURCE", "CREATED")
+
+	for _, domain := range a.staticDomains() {
+		t.Append(domain, "options", "")
+	}
+
+	if a.opts.DynamicDomains {
+		defer a.Close()
+
+		rds, err := a.registeredDomains(ctx)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, rd := range rds {
+			t.Append(rd.Name, "registry", rd.Created.Format(time.RFC3339))
+		}
+	}
+
+	return t.Print()
+}
+
 func (a *App) cliInit(ctx stdcli.Context) error {
 	name := ctx.Arg(0)
 
@@ -95,6 +180,34 @@ func (a *App) cliInit(ctx stdcli.Context) error {
 	return nil
 }
 
+func (a *App) cliJobDead(ctx stdcli.Context) error {
+	jobs, err := a.deadJobs(ctx, coalesce.String(ctx.Flags().String("domain"), a.domains()[0]))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	t := ctx.Table("ID", "KIND", "ATTEMPTS", "FINISHED", "ERROR")
+
+	for _, j := range jobs {
+		t.Append(j.ID, j.Kind, j.Attempts, j.Finished.Format(time.RFC3339), j.Error)
+	}
+
+	return t.Print()
+}
+
+func (a *App) cliJobRetry(ctx stdcli.Context) error {
+	id, err := strconv.ParseInt(ctx.Arg(0), 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid job id: %s", ctx.Arg(0))
+	}
+
+	if err := a.retryJob(ctx, coalesce.String(ctx.Flags().String("domain"), a.domains()[0]), id); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
 func (a *App) cliMigrate(ctx stdcli.Context) error {
 	args := []string{}
 	dry := false
@@ -108,30 +221,67 @@ func (a *App) cliMigrate(ctx stdcli.Context) error {
 		return a.run("api", "go", append([]string{"run", ".", "migrate"}, args...)...)
 	}
 
-	u, err := url.Parse(a.opts.Database)
-	if err != nil {
+	if err := a.Migrate(ctx, migrate.Options{DryRun: dry}); err != nil {
 		return errors.Wrap(err)
 	}
 
-	mopts := migrate.Options{
-		DryRun: dry,
+	return nil
+}
+
+func (a *App) cliMigrateStatus(ctx stdcli.Context) error {
+	if a.opts.Compose {
+		args := []string{"run", ".", "migrate", "status"}
+
+		if o := ctx.Flags().String("output"); o != "" {
+			args = append(args, "--output", o)
+		}
+
+		if ctx.Flags().Bool("check") {
+			args = append(args, "--check")
+		}
+
+		return a.run("api", "go", args...)
 	}
 
-	for _, domain := range append([]string{""}, a.domains()...) {
-		q := u.Query()
-		q.Set("search_path", domain)
-		u.RawQuery = q.Encode()
+	defer a.Close()
+
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	t := ctx.Table("DOMAIN", "VERSION", "STATE", "APPLIED")
+
+	outstanding := 0
 
-		mfs, err := fs.Sub(a.opts.Migrations, filepath.Join("db", "migrate", domain))
+	for _, domain := range append([]string{""}, a.domains()...) {
+		states, err := a.migrationStatus(ctx, domain)
 		if err != nil {
 			return errors.Wrap(err)
 		}
 
-		if err := migrate.Run(context.Background(), u.String(), mfs, mopts); err != nil {
-			return errors.Wrap(err)
+		for _, s := range states {
+			applied := ""
+
+			if !s.Applied.IsZero() {
+				applied = s.Applied.Format(time.RFC3339)
+			}
+
+			if s.State != "applied" {
+				outstanding++
+			}
+
+			t.Append(domain, s.Version, s.State, applied)
 		}
 	}
 
+	if err := t.Print(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if ctx.Flags().Bool("check") && outstanding > 0 {
+		return errors.Errorf("%d migrations are pending or missing", outstanding)
+	}
+
 	return nil
 }
 
@@ -174,13 +324,207 @@ func (a *App) cliPgReset(ctx stdcli.Context) error {
 	return a.run("postgres", "psql", a.opts.Database, "-c", "drop schema public cascade; create schema public;")
 }
 
+func (a *App) cliQueriesExtract(ctx stdcli.Context) error {
+	manifest, err := extractQueries(ctx.Args())
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	ctx.Writef("%s\n", data)
+
+	return nil
+}
+
+func (a *App) cliScheduleList(ctx stdcli.Context) error {
+	specs, err := a.schedules()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	results, err := a.scheduleResults(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	t := ctx.Table("NAME", "SPEC", "DOMAIN", "NEXT", "LAST", "RESULT")
+
+	for _, s := range a.opts.Schedules {
+		next := specs[s.Name].Next(time.Now()).Format(time.RFC3339)
+
+		domains := []string{""}
+
+		if s.Domain {
+			domains = a.domains()
+		}
+
+		for _, domain := range domains {
+			r := results[fmt.Sprintf("%s/%s", s.Name, domain)]
+
+			last := ""
+
+			if !r.Started.IsZero() {
+				last = r.Started.Format(time.RFC3339)
+			}
+
+			t.Append(s.Name, s.Spec, domain, next, last, r.String())
+		}
+	}
+
+	return t.Print()
+}
+
+func (a *App) cliScheduler(ctx stdcli.Context) error {
+	if ctx.Flags().Bool("development") {
+		return a.watchAndReload(parseExtensions(ctx.Flags().String("watch")), "scheduler")
+	}
+
+	defer a.Close()
+
+	sctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	drain := coalesce.Any(flagDuration(ctx, "drain"), a.opts.ShutdownTimeout, shutdownTimeout)
+
+	return a.lifecycle(sctx, func(ctx context.Context) error {
+		return a.schedule(ctx, drain)
+	})
+}
+
+func (a *App) cliSchemaCheck(ctx stdcli.Context) error {
+	against := ctx.Flags().String("against")
+
+	if against == "" {
+		return errors.Errorf("--against is required")
+	}
+
+	stat, err := os.Stat(against)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	files := map[string]string{}
+
+	switch {
+	case stat.IsDir():
+		for _, domain := range a.domains() {
+			for _, ext := range []string{"json", "graphql"} {
+				file := filepath.Join(against, fmt.Sprintf("%s.%s", domain, ext))
+
+				if _, err := os.Stat(file); err == nil {
+					files[domain] = file
+					break
+				}
+			}
+
+			if files[domain] == "" {
+				ctx.Writef("%s: no previous schema in %s\n", domain, against)
+			}
+		}
+	default:
+		files[coalesce.String(ctx.Flags().String("domain"), a.domains()[0])] = against
+	}
+
+	breaking := 0
+
+	for _, domain := range a.domains() {
+		if files[domain] == "" {
+			continue
+		}
+
+		changes, err := a.checkSchema(domain, files[domain])
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, c := range changes {
+			ctx.Writef("%s: %s: %s\n", domain, c.Path, c.Message)
+		}
+
+		breaking += len(changes)
+	}
+
+	if breaking > 0 {
+		return errors.Errorf("%d breaking changes", breaking)
+	}
+
+	ctx.Writef("no breaking changes\n")
+
+	return nil
+}
+
+func (a *App) cliSchemaDump(ctx stdcli.Context) error {
+	domains := a.domains()
+
+	if domain := ctx.Flags().String("domain"); domain != "" {
+		domains = []string{domain}
+	}
+
+	for _, domain := range domains {
+		files, err := a.dumpSchema(domain, coalesce.String(ctx.Flags().String("dir"), "schema"))
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, file := range files {
+			ctx.Writef("%s\n", file)
+		}
+	}
+
+	return nil
+}
+
+func (a *App) cliServe(ctx stdcli.Context) error {
+	if ctx.Flags().Bool("development") {
+		return a.watchAndReload(parseExtensions(ctx.Flags().String("watch")), "serve", "--port", fmt.Sprint(ctx.Flags().Int("port")))
+	}
+
+	s, err := a.serve()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer s.api.Close()
+
+	if err := a.listen(ctx, s.server, a.listenOptions(ctx)); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
 func (a *App) cliSleep(ctx stdcli.Context) error {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	<-ch
 	return nil
 }
 
+func (a *App) cliWorker(ctx stdcli.Context) error {
+	if ctx.Flags().Bool("development") {
+		return a.watchAndReload(parseExtensions(ctx.Flags().String("watch")), "worker", "--concurrency", fmt.Sprint(ctx.Flags().Int("concurrency")))
+	}
+
+	defer a.Close()
+
+	sctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	concurrency := coalesce.Any(ctx.Flags().Int("concurrency"), jobConcurrency)
+	drain := coalesce.Any(flagDuration(ctx, "drain"), a.opts.ShutdownTimeout, shutdownTimeout)
+
+	return a.lifecycle(sctx, func(ctx context.Context) error {
+		return a.work(ctx, concurrency, drain)
+	})
+}
+
 func (a *App) cliWeb(ctx stdcli.Context) error {
 	if ctx.Flags().Bool("development") {
 		return a.webDevelopment()
@@ -191,9 +535,7 @@ func (a *App) cliWeb(ctx stdcli.Context) error {
 		return errors.Wrap(err)
 	}
 
-	port := coalesce.Any(ctx.Flags().Int("port"), 8000)
-
-	if err := s.server.Listen("https", fmt.Sprintf(":%d", port)); err != nil {
+	if err := a.listen(ctx, s.server, a.listenOptions(ctx)); err != nil {
 		return errors.Wrap(err)
 	}
 
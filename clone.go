@@ -0,0 +1,503 @@
+package stdapp
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"go.ddollar.dev/errors"
+)
+
+type CloneOptions struct {
+	Anonymize bool
+}
+
+// CloneDomain copies a domain's schema into a new domain in the registry:
+// its tables with their data, including the versions in _migrations and
+// their times in _migration_times, sequences at their current values,
+// functions, views and triggers. Jobs are left behind so that the clone
+// doesn't repeat their side effects. With Anonymize the columns in
+// Options.Anonymize are replaced as they are copied, and a column that
+// doesn't exist is an error.
+//
+// Function bodies are copied as text: references qualified with the source
+// schema are pointed at the copy, even inside string literals and comments,
+// and functions are pinned to the copy's search_path so that unqualified
+// references resolve there. Names built dynamically, such as with EXECUTE,
+// are not rewritten.
+func (a *App) CloneDomain(ctx context.Context, src, dst string, opts CloneOptions) error {
+	if opts.Anonymize && len(a.opts.Anonymize) == 0 {
+		return errors.Errorf("no columns to anonymize")
+	}
+
+	if err := a.checkNewDomain(ctx, dst); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if !slices.Contains(a.domains(), src) {
+		return errors.Errorf("no such domain: %s", src)
+	}
+
+	db, err := a.DB("")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	c := &schemaClone{ctx: ctx, dst: a.Schema(dst), src: a.Schema(src), tx: tx}
+
+	if opts.Anonymize {
+		c.anonymize = a.opts.Anonymize
+	}
+
+	if err := c.run(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := tx.NewInsert().Table("_domains").Value("name", "?", dst).Exec(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return a.domainsChanged(ctx)
+}
+
+// objects that belong to an extension installed in the schema, such as its
+// functions in public, are left to the extension
+const cloneOwn = "NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.objid = %s.oid AND e.deptype = 'e')"
+
+// schemaClone copies one schema into another in a transaction. Definitions
+// are read with an empty search_path so that postgres qualifies every name,
+// and references to objects in the source schema that are copied are then
+// pointed at the copy.
+type schemaClone struct {
+	anonymize map[string]string
+	ctx       context.Context
+	dst       string
+	objects   map[string]bool
+	qualified *regexp.Regexp
+	src       string
+	tx        bun.Tx
+}
+
+type cloneDefinition struct {
+	Name  string `bun:"name"`
+	Table string `bun:"table_name"`
+	Value string `bun:"value"`
+}
+
+type cloneFunction struct {
+	Args      string `bun:"args"`
+	Name      string `bun:"name"`
+	Pin       bool   `bun:"pin"`
+	Procedure bool   `bun:"procedure"`
+	Value     string `bun:"value"`
+}
+
+type cloneSequence struct {
+	Cache     int64  `bun:"cache_size"`
+	Cycle     bool   `bun:"cycle"`
+	Increment int64  `bun:"increment_by"`
+	Last      *int64 `bun:"last_value"`
+	Max       int64  `bun:"max_value"`
+	Min       int64  `bun:"min_value"`
+	Name      string `bun:"sequencename"`
+	Start     int64  `bun:"start_value"`
+	Type      string `bun:"data_type"`
+}
+
+func (c *schemaClone) run() error {
+	var qsrc string
+
+	if err := c.tx.NewRaw("SELECT quote_ident(?)", c.src).Scan(c.ctx, &qsrc); err != nil {
+		return errors.Wrap(err)
+	}
+
+	c.qualified = regexp.MustCompile(`(^|[^A-Za-z0-9_$"])` + regexp.QuoteMeta(qsrc) + `\.("(?:[^"]|"")+"|[A-Za-z0-9_$]+)`)
+
+	objects := []string{}
+
+	if err := c.tx.NewRaw(fmt.Sprintf(`
+		SELECT t.relname FROM pg_class t JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = ? AND t.relkind IN ('r', 'S', 'v') AND %s
+		UNION
+		SELECT p.proname FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname = ? AND p.prokind IN ('f', 'p') AND %s
+	`, fmt.Sprintf(cloneOwn, "t"), fmt.Sprintf(cloneOwn, "p")), c.src, c.src).Scan(c.ctx, &objects); err != nil {
+		return errors.Wrap(err)
+	}
+
+	c.objects = map[string]bool{}
+
+	for _, o := range objects {
+		c.objects[o] = true
+	}
+
+	var path string
+
+	if err := c.tx.NewRaw("SELECT current_setting('search_path')").Scan(c.ctx, &path); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := c.exec("SELECT set_config('search_path', '', true), set_config('check_function_bodies', 'false', true)"); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := c.exec(fmt.Sprintf("CREATE SCHEMA %s", quoteIdentifier(c.dst))); err != nil {
+		return errors.Wrap(err)
+	}
+
+	steps := []func() error{c.functions, c.tables, c.sequences, c.defaults, c.data, c.constraints, c.views, c.triggers}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	// the registry is written in the same transaction
+	if _, err := c.tx.ExecContext(c.ctx, "SELECT set_config('search_path', ?, true)", path); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// functions are copied with their bodies rewritten as text, which only finds
+// names qualified with the source schema, so a function that doesn't set its
+// own search_path, or sets it to the source, is pinned to the copy to keep
+// unqualified names in its body from resolving elsewhere
+func (c *schemaClone) functions() error {
+	fns := []cloneFunction{}
+
+	if err := c.tx.NewRaw(fmt.Sprintf(`
+		SELECT p.proname AS name, pg_get_function_identity_arguments(p.oid) AS args,
+			p.prokind = 'p' AS procedure, pg_get_functiondef(p.oid) AS value,
+			NOT EXISTS (
+				SELECT 1 FROM unnest(p.proconfig) s
+				WHERE starts_with(s, 'search_path=') AND s NOT IN ('search_path=' || ?, 'search_path=' || quote_ident(?))
+			) AS pin
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname = ? AND p.prokind IN ('f', 'p') AND %s
+		ORDER BY p.oid
+	`, fmt.Sprintf(cloneOwn, "p")), c.src, c.src, c.src).Scan(c.ctx, &fns); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, fn := range fns {
+		if err := c.exec(c.rewrite(fn.Value)); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if !fn.Pin {
+			continue
+		}
+
+		kind := "FUNCTION"
+
+		if fn.Procedure {
+			kind = "PROCEDURE"
+		}
+
+		if err := c.exec(fmt.Sprintf("ALTER %s %s(%s) SET search_path = %s", kind, c.name(c.dst, fn.Name), fn.Args, quoteIdentifier(c.dst))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (c *schemaClone) tables() error {
+	tables, err := c.tableNames()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, table := range tables {
+		if err := c.exec(fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING ALL)", c.name(c.dst, table), c.name(c.src, table))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// sequences creates those that aren't identity columns, which the tables
+// brought along, and sets all of them to where the source's are
+func (c *schemaClone) sequences() error {
+	seqs := []cloneSequence{}
+
+	if err := c.tx.NewRaw(`
+		SELECT sequencename, data_type::text, start_value, min_value, max_value, increment_by, cycle, cache_size, last_value
+		FROM pg_sequences WHERE schemaname = ? ORDER BY sequencename
+	`, c.src).Scan(c.ctx, &seqs); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, s := range seqs {
+		if !c.objects[s.Name] {
+			continue
+		}
+
+		var exists bool
+
+		if err := c.tx.NewRaw("SELECT to_regclass(?) IS NOT NULL", c.name(c.dst, s.Name)).Scan(c.ctx, &exists); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if !exists {
+			cycle := "NO CYCLE"
+
+			if s.Cycle {
+				cycle = "CYCLE"
+			}
+
+			if err := c.exec(fmt.Sprintf("CREATE SEQUENCE %s AS %s INCREMENT BY %d MINVALUE %d MAXVALUE %d START WITH %d CACHE %d %s", c.name(c.dst, s.Name), s.Type, s.Increment, s.Min, s.Max, s.Start, s.Cache, cycle)); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		if s.Last != nil {
+			if _, err := c.tx.ExecContext(c.ctx, "SELECT setval(?, ?, true)", c.name(c.dst, s.Name), *s.Last); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+	}
+
+	owned := []cloneDefinition{}
+
+	if err := c.tx.NewRaw(`
+		SELECT t.relname AS table_name, a.attname AS name, s.relname AS value FROM pg_depend d
+		JOIN pg_class s ON s.oid = d.objid AND s.relkind = 'S'
+		JOIN pg_class t ON t.oid = d.refobjid
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+		JOIN pg_namespace n ON n.oid = s.relnamespace
+		WHERE d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass AND d.deptype = 'a' AND n.nspname = ?
+	`, c.src).Scan(c.ctx, &owned); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, o := range owned {
+		if !c.objects[o.Value] || !c.objects[o.Table] {
+			continue
+		}
+
+		if err := c.exec(fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s", c.name(c.dst, o.Value), c.name(c.dst, o.Table), quoteIdentifier(o.Name))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// defaults copied with the tables still call nextval on the source's
+// sequences
+func (c *schemaClone) defaults() error {
+	defaults := []cloneDefinition{}
+
+	if err := c.tx.NewRaw(`
+		SELECT t.relname AS table_name, a.attname AS name, pg_get_expr(d.adbin, d.adrelid) AS value FROM pg_attrdef d
+		JOIN pg_class t ON t.oid = d.adrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_attribute a ON a.attrelid = d.adrelid AND a.attnum = d.adnum
+		WHERE n.nspname = ? AND a.attgenerated = ''
+	`, c.dst).Scan(c.ctx, &defaults); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, d := range defaults {
+		if def := c.rewrite(d.Value); def != d.Value {
+			if err := c.exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", c.name(c.dst, d.Table), quoteIdentifier(d.Name), def)); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *schemaClone) data() error {
+	tables, err := c.tableNames()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	anonymized := map[string]bool{}
+
+	for _, table := range tables {
+		if table == "_jobs" {
+			continue
+		}
+
+		columns := []string{}
+
+		if err := c.tx.NewRaw(`
+			SELECT a.attname FROM pg_attribute a
+			JOIN pg_class t ON t.oid = a.attrelid
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			WHERE n.nspname = ? AND t.relname = ? AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+			ORDER BY a.attnum
+		`, c.src, table).Scan(c.ctx, &columns); err != nil {
+			return errors.Wrap(err)
+		}
+
+		names := make([]string, len(columns))
+		values := make([]string, len(columns))
+
+		for i, column := range columns {
+			names[i] = quoteIdentifier(column)
+			values[i] = quoteIdentifier(column)
+
+			if expr, ok := c.anonymize[table+"."+column]; ok {
+				values[i] = fmt.Sprintf("(%s)", expr)
+				anonymized[table+"."+column] = true
+			}
+		}
+
+		if err := c.exec(fmt.Sprintf("INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM %s", c.name(c.dst, table), strings.Join(names, ", "), strings.Join(values, ", "), c.name(c.src, table))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	// a key that matches nothing would otherwise leave its column as it was
+	for _, key := range slices.Sorted(maps.Keys(c.anonymize)) {
+		if !anonymized[key] {
+			return errors.Errorf("no such column to anonymize: %s", key)
+		}
+	}
+
+	return nil
+}
+
+// constraints adds foreign keys, which LIKE leaves out, once the data is in
+func (c *schemaClone) constraints() error {
+	fks := []cloneDefinition{}
+
+	if err := c.tx.NewRaw(fmt.Sprintf(`
+		SELECT t.relname AS table_name, k.conname AS name, pg_get_constraintdef(k.oid) AS value FROM pg_constraint k
+		JOIN pg_class t ON t.oid = k.conrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = ? AND k.contype = 'f' AND %s
+		ORDER BY k.oid
+	`, fmt.Sprintf(cloneOwn, "t")), c.src).Scan(c.ctx, &fks); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, fk := range fks {
+		if err := c.exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", c.name(c.dst, fk.Table), quoteIdentifier(fk.Name), c.rewrite(fk.Value))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (c *schemaClone) views() error {
+	views := []cloneDefinition{}
+
+	if err := c.tx.NewRaw(fmt.Sprintf(`
+		SELECT t.relname AS name, pg_get_viewdef(t.oid) AS value FROM pg_class t
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = ? AND t.relkind = 'v' AND %s
+		ORDER BY t.oid
+	`, fmt.Sprintf(cloneOwn, "t")), c.src).Scan(c.ctx, &views); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, v := range views {
+		if err := c.exec(fmt.Sprintf("CREATE VIEW %s AS %s", c.name(c.dst, v.Name), c.rewrite(v.Value))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// triggers come last so that copying the data doesn't fire them
+func (c *schemaClone) triggers() error {
+	defs := []string{}
+
+	if err := c.tx.NewRaw(fmt.Sprintf(`
+		SELECT pg_get_triggerdef(g.oid) FROM pg_trigger g
+		JOIN pg_class t ON t.oid = g.tgrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = ? AND NOT g.tgisinternal AND %s
+		ORDER BY g.oid
+	`, fmt.Sprintf(cloneOwn, "t")), c.src).Scan(c.ctx, &defs); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, def := range defs {
+		if err := c.exec(c.rewrite(def)); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (c *schemaClone) tableNames() ([]string, error) {
+	tables := []string{}
+
+	if err := c.tx.NewRaw(fmt.Sprintf(`
+		SELECT t.relname FROM pg_class t
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = ? AND t.relkind = 'r' AND NOT t.relispartition AND %s
+	`, fmt.Sprintf(cloneOwn, "t")), c.src).Scan(c.ctx, &tables); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	sort.Strings(tables)
+
+	return tables, nil
+}
+
+// exec runs generated sql without arguments, so that bun leaves any ? in
+// definitions alone
+func (c *schemaClone) exec(query string) error {
+	if _, err := c.tx.ExecContext(c.ctx, query); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (c *schemaClone) name(schema, name string) string {
+	return fmt.Sprintf("%s.%s", quoteIdentifier(schema), quoteIdentifier(name))
+}
+
+// rewrite points the names in a definition that postgres qualified with the
+// source schema at the copy, unless they aren't being copied. It works on the
+// text, so in a function body it also rewrites matches in string literals and
+// comments, and leaves unqualified names alone.
+func (c *schemaClone) rewrite(def string) string {
+	return c.qualified.ReplaceAllStringFunc(def, func(match string) string {
+		m := c.qualified.FindStringSubmatch(match)
+
+		name := m[2]
+
+		if strings.HasPrefix(name, `"`) {
+			name = strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+		}
+
+		if !c.objects[name] {
+			return match
+		}
+
+		return m[1] + quoteIdentifier(c.dst) + "." + m[2]
+	})
+}
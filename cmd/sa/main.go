@@ -11,9 +11,6 @@ import (
 )
 
 type kip struct {
-	Resources map[string]struct {
-		Type string `yaml:"type"`
-	} `yaml:"resources"`
 	Services map[string]struct {
 		Environment map[string]string `yaml:"environment"`
 	} `yaml:"services"`
@@ -62,13 +59,7 @@ func options() (*stdapp.Options, error) {
 	}
 	opts.Name = filepath.Base(cwd)
 
-	// Check if postgres resource exists to set DATABASE_URL
-	if _, ok := k.Resources["postgres"]; ok {
-		// If DATABASE_URL is not already set in environment, kip will inject it
-		if dburl := os.Getenv("DATABASE_URL"); dburl != "" {
-			opts.Database = dburl
-		}
-	}
+	// DATABASE_URL from the environment is loaded by stdapp and takes precedence
 
 	// Check services for explicit DATABASE_URL
 	if api, ok := k.Services["api"]; ok {
@@ -0,0 +1,334 @@
+package stdapp
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/stdcli"
+	"gopkg.in/yaml.v3"
+)
+
+// configFields lists the Options that can be set outside of code, in order of
+// precedence they come from Options, the config file and finally command
+// flags where a command has one. The environment only fills fields that are
+// still empty, so that variables a platform sets for its own reasons, like
+// PORT, don't override what the code asks for.
+var configFields = []configField{
+	{Field: "CertificateCache", Key: "cert_cache", Env: "CERT_CACHE"},
+	{Field: "CertificateFile", Key: "cert", Env: "CERT_FILE"},
+	{Field: "Compose", Key: "compose", Env: "COMPOSE"},
+	{Field: "ConnMaxIdleTime", Key: "conn_max_idle_time", Env: "CONN_MAX_IDLE_TIME"},
+	{Field: "ConnMaxLifetime", Key: "conn_max_lifetime", Env: "CONN_MAX_LIFETIME"},
+	{Field: "Database", Key: "database", Env: "DATABASE_URL", Secret: true},
+	{Field: "Development", Key: "development", Env: "DEVELOPMENT"},
+	{Field: "Domains", Key: "domains", Env: "DOMAINS"},
+	{Field: "DynamicDomains", Key: "dynamic_domains", Env: "DYNAMIC_DOMAINS"},
+	{Field: "GraphiQL", Key: "graphiql", Env: "GRAPHIQL"},
+	{Field: "JobTimeout", Key: "job_timeout", Env: "JOB_TIMEOUT"},
+	{Field: "KeyFile", Key: "key", Env: "KEY_FILE"},
+	{Field: "MaxComplexity", Key: "max_complexity", Env: "MAX_COMPLEXITY"},
+	{Field: "MaxDepth", Key: "max_depth", Env: "MAX_DEPTH"},
+	{Field: "MaxIdleConns", Key: "max_idle_conns", Env: "MAX_IDLE_CONNS"},
+	{Field: "MaxOpenConns", Key: "max_open_conns", Env: "MAX_OPEN_CONNS"},
+	{Field: "OperationTimeout", Key: "operation_timeout", Env: "OPERATION_TIMEOUT"},
+	{Field: "PersistedQueries", Key: "persisted_queries", Env: "PERSISTED_QUERIES"},
+	{Field: "Port", Key: "port", Env: "PORT"},
+	{Field: "Protocol", Key: "proto", Env: "PROTOCOL"},
+	{Field: "QueryAllowList", Key: "query_allow_list", Env: "QUERY_ALLOW_LIST"},
+	{Field: "RedactVariables", Key: "redact_variables", Env: "REDACT_VARIABLES"},
+	{Field: "RootSchema", Key: "root_schema", Env: "ROOT_SCHEMA"},
+	{Field: "SchemaPrefix", Key: "schema_prefix", Env: "SCHEMA_PREFIX"},
+	{Field: "ShutdownTimeout", Key: "shutdown_timeout", Env: "SHUTDOWN_TIMEOUT"},
+	{Field: "SlowOperation", Key: "slow_operation", Env: "SLOW_OPERATION"},
+	{Field: "StatementTimeout", Key: "statement_timeout", Env: "STATEMENT_TIMEOUT"},
+	{Field: "WriteTimeout", Key: "write_timeout", Env: "WRITE_TIMEOUT"},
+}
+
+var flagConfig = stdcli.StringFlag("config", "", "config file to load (default $CONFIG_FILE)")
+
+type configField struct {
+	Env    string
+	Field  string
+	Key    string
+	Secret bool
+}
+
+type configValue struct {
+	Env    string `json:"env"`
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Value  string `json:"value"`
+}
+
+// configure wraps a command so that Options are loaded before it runs,
+// database is whether the command needs one
+func (a *App) configure(fn stdcli.HandlerFunc, database bool) stdcli.HandlerFunc {
+	return func(ctx stdcli.Context) error {
+		if err := a.loadConfig(coalesce.String(ctx.Flags().String("config"), os.Getenv("CONFIG_FILE")), database); err != nil {
+			return errors.Wrap(err)
+		}
+
+		return fn(ctx)
+	}
+}
+
+// LoadConfig applies a config file, when file isn't empty, and the
+// environment to Options and validates them the way every command does
+// before it runs. Code that uses an App without running a command, such as a
+// test, can call it itself.
+func (a *App) LoadConfig(file string) error {
+	return a.loadConfig(file, false)
+}
+
+func (a *App) loadConfig(file string, database bool) error {
+	a.sources = map[string]string{}
+
+	if file != "" {
+		if err := a.loadConfigFile(file); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	for _, f := range configFields {
+		v, ok := os.LookupEnv(f.Env)
+		if !ok || !f.value(&a.opts).IsZero() {
+			continue
+		}
+
+		if err := f.set(&a.opts, v); err != nil {
+			return errors.Errorf("invalid %s: %s", f.Env, err)
+		}
+
+		a.sources[f.Field] = "env"
+	}
+
+	if err := a.validateConfig(database); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) loadConfigFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	values := map[string]any{}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return errors.Errorf("invalid config file %s: %s", file, err)
+	}
+
+	fields := map[string]configField{}
+
+	for _, f := range configFields {
+		fields[f.Key] = f
+	}
+
+	for key, value := range values {
+		f, ok := fields[key]
+		if !ok {
+			return errors.Errorf("unknown key in config file %s: %s", file, key)
+		}
+
+		if err := f.set(&a.opts, configString(value)); err != nil {
+			return errors.Errorf("invalid %s in config file %s: %s", key, file, err)
+		}
+
+		a.sources[f.Field] = "file"
+	}
+
+	return nil
+}
+
+func (a *App) validateConfig(database bool) error {
+	// commands passed through to compose find the database there
+	if database && !a.opts.Compose {
+		if _, err := a.databaseURL(); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if a.opts.Database != "" {
+		u, err := url.Parse(a.opts.Database)
+		if err != nil {
+			return errors.Errorf("invalid database url: %s", redactURL(a.opts.Database))
+		}
+
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return errors.Errorf("invalid database url: scheme must be postgres")
+		}
+	}
+
+	switch a.opts.Protocol {
+	case "", "http", "h2", "https", "tls":
+	default:
+		return errors.Errorf("invalid protocol: %s", a.opts.Protocol)
+	}
+
+	switch a.opts.PersistedQueries {
+	case "", "memory":
+	case "postgres":
+		// without an allow-list any client could fill the table
+		if a.opts.QueryAllowList == "" {
+			return errors.Errorf("persisted query store postgres requires a query allow-list")
+		}
+	default:
+		return errors.Errorf("invalid persisted query store: %s", a.opts.PersistedQueries)
+	}
+
+	if a.opts.Port < 0 || a.opts.Port > 65535 {
+		return errors.Errorf("invalid port: %d", a.opts.Port)
+	}
+
+	if (a.opts.CertificateFile == "") != (a.opts.KeyFile == "") {
+		return errors.Errorf("certificate and key files must be set together")
+	}
+
+	for column, expr := range a.opts.Anonymize {
+		if table, name, ok := strings.Cut(column, "."); !ok || table == "" || name == "" || expr == "" {
+			return errors.Errorf("invalid anonymize column: %s", column)
+		}
+	}
+
+	for _, f := range configFields {
+		if d, ok := f.value(&a.opts).Interface().(time.Duration); ok && d < 0 {
+			return errors.Errorf("invalid %s: must not be negative", f.Key)
+		}
+	}
+
+	return a.validateDomainConfig()
+}
+
+func (a *App) databaseURL() (string, error) {
+	if a.opts.Database == "" {
+		return "", errors.Errorf("no database configured, set DATABASE_URL or database in the config file")
+	}
+
+	return a.opts.Database, nil
+}
+
+func (a *App) effectiveConfig() []configValue {
+	values := []configValue{}
+
+	for _, f := range configFields {
+		v := configValue{
+			Env:    f.Env,
+			Key:    f.Key,
+			Source: a.sources[f.Field],
+			Value:  f.format(&a.opts),
+		}
+
+		if v.Source == "" && f.value(&a.opts).IsZero() {
+			v.Source = "default"
+		}
+
+		v.Source = coalesce.String(v.Source, "code")
+
+		values = append(values, v)
+	}
+
+	return values
+}
+
+func (f configField) format(opts *Options) string {
+	v := f.value(opts)
+
+	switch t := v.Interface().(type) {
+	case []string:
+		return strings.Join(t, ",")
+	case string:
+		if f.Secret {
+			return redactURL(t)
+		}
+		return t
+	default:
+		if v.IsZero() {
+			return ""
+		}
+		return fmt.Sprint(t)
+	}
+}
+
+func (f configField) set(opts *Options, s string) error {
+	v := f.value(opts)
+
+	switch v.Interface().(type) {
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.Errorf("not a boolean: %s", s)
+		}
+		v.SetBool(b)
+	case int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.Errorf("not an integer: %s", s)
+		}
+		v.SetInt(int64(i))
+	case string:
+		v.SetString(s)
+	case []string:
+		items := []string{}
+
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(items))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.Errorf("not a duration: %s", s)
+		}
+		v.SetInt(int64(d))
+	default:
+		return errors.Errorf("unsupported type: %s", v.Type())
+	}
+
+	return nil
+}
+
+func (f configField) value(opts *Options) reflect.Value {
+	return reflect.ValueOf(opts).Elem().FieldByName(f.Field)
+}
+
+func configString(v any) string {
+	switch t := v.(type) {
+	case []any:
+		parts := make([]string, len(t))
+
+		for i := range t {
+			parts[i] = fmt.Sprint(t[i])
+		}
+
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// redactURL hides the password in a url, and the whole value if it doesn't parse
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return "REDACTED"
+	}
+
+	return u.Redacted()
+}
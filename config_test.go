@@ -0,0 +1,87 @@
+package stdapp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		file     string
+		env      map[string]string
+		database bool
+		port     int
+		domains  []string
+		err      string
+	}{
+		{name: "env fills empty", env: map[string]string{"PORT": "9000"}, port: 9000},
+		{name: "env doesn't override code", opts: Options{Port: 8000}, env: map[string]string{"PORT": "9000"}, port: 8000},
+		{name: "file overrides code", opts: Options{Port: 8000}, file: "port: 7000\n", port: 7000},
+		{name: "env doesn't override file", file: "port: 7000\n", env: map[string]string{"PORT": "9000"}, port: 7000},
+		{name: "env list", env: map[string]string{"DOMAINS": "public,admin"}, domains: []string{"public", "admin"}},
+		{name: "invalid env", env: map[string]string{"PORT": "http"}, err: "invalid PORT"},
+		{name: "unknown key", file: "prot: 80\n", err: "unknown key"},
+		{name: "database required", database: true, err: "no database configured"},
+		{name: "database from env", database: true, env: map[string]string{"DATABASE_URL": "postgres://localhost/app"}},
+		{name: "database through compose", opts: Options{Compose: true}, database: true},
+		{name: "database not required", opts: Options{Port: 8000}, port: 8000},
+		{name: "database scheme", opts: Options{Database: "mysql://localhost/app"}, err: "scheme must be postgres"},
+		{name: "postgres queries without allow-list", opts: Options{PersistedQueries: "postgres"}, err: "requires a query allow-list"},
+		{name: "postgres queries with allow-list", opts: Options{PersistedQueries: "postgres", Port: 8000, QueryAllowList: "queries.json"}, port: 8000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, f := range configFields {
+				if _, ok := os.LookupEnv(f.Env); ok {
+					t.Setenv(f.Env, "")
+					os.Unsetenv(f.Env)
+				}
+			}
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			file := ""
+
+			if tt.file != "" {
+				file = filepath.Join(t.TempDir(), "config.yml")
+
+				if err := os.WriteFile(file, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a, err := New(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = a.loadConfig(file, tt.database)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a.opts.Port != tt.port {
+				t.Fatalf("expected port %d, got %d", tt.port, a.opts.Port)
+			}
+
+			if strings.Join(a.opts.Domains, ",") != strings.Join(tt.domains, ",") {
+				t.Fatalf("expected domains %q, got %q", tt.domains, a.opts.Domains)
+			}
+		})
+	}
+}
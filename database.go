@@ -0,0 +1,284 @@
+package stdapp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/stdapi"
+)
+
+type contextKey string
+
+var contextSchema = contextKey("schema")
+
+// all domains share a single pool, each domain's *bun.DB tags its queries with
+// the domain schema and the connection switches search_path when it differs
+func (a *App) database() (*sql.DB, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.sqldb != nil {
+		return a.sqldb, nil
+	}
+
+	dsn, err := a.databaseURL()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	opts := []pgdriver.Option{
+		pgdriver.WithDSN(dsn),
+	}
+
+	if a.opts.StatementTimeout > 0 {
+		opts = append(opts, pgdriver.WithConnParams(map[string]interface{}{
+			"statement_timeout": a.opts.StatementTimeout.Milliseconds(),
+		}))
+	}
+
+	db := sql.OpenDB(searchPathConnector{pgdriver.NewConnector(opts...)})
+
+	db.SetConnMaxIdleTime(a.opts.ConnMaxIdleTime)
+	db.SetConnMaxLifetime(a.opts.ConnMaxLifetime)
+	db.SetMaxIdleConns(a.opts.MaxIdleConns)
+	db.SetMaxOpenConns(a.opts.MaxOpenConns)
+
+	a.sqldb = db
+
+	return db, nil
+}
+
+func (a *App) DB(domain string) (*bun.DB, error) {
+	sdb, err := a.database()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if db, ok := a.dbs[domain]; ok {
+		return db, nil
+	}
+
+	db := bun.NewDB(sdb, pgdialect.New())
+
+	db.AddQueryHook(schemaHook(a.Schema(domain)))
+
+	a.dbs[domain] = db
+
+	return db, nil
+}
+
+func (a *App) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.sqldb == nil {
+		return nil
+	}
+
+	errs := []error{}
+
+	for _, n := range a.notifiers {
+		errs = append(errs, n.close())
+	}
+
+	errs = append(errs, a.sqldb.Close())
+
+	a.sqldb = nil
+	a.dbs = map[string]*bun.DB{}
+	a.notifiers = map[string]*Notifier{}
+
+	return errors.Join(errs...)
+}
+
+func (a *App) DBStats() sql.DBStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.sqldb == nil {
+		return sql.DBStats{}
+	}
+
+	return a.sqldb.Stats()
+}
+
+func (a *App) handleStats(s *stdapi.Server) {
+	s.Route("GET", "/stats/db", a.statsDB)
+}
+
+func (a *App) statsDB(c *stdapi.Context) error {
+	return c.RenderJSON(a.DBStats())
+}
+
+// Schema is the database schema of a domain, for the root it's RootSchema
+// and when that's empty the server's default search_path
+func (a *App) Schema(domain string) string {
+	if domain == "" {
+		return a.opts.RootSchema
+	}
+
+	return a.opts.SchemaPrefix + domain
+}
+
+type schemaHook string
+
+func (h schemaHook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return context.WithValue(ctx, contextSchema, string(h))
+}
+
+func (h schemaHook) AfterQuery(context.Context, *bun.QueryEvent) {}
+
+type searchPathConnector struct {
+	driver.Connector
+}
+
+func (c searchPathConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &searchPathConn{Conn: cn}, nil
+}
+
+// searchPathConn sets search_path before each statement whose context names
+// a schema other than the one the connection is on. Statements sent without
+// a domain's *bun.DB, such as through a raw *sql.DB or bun.DB.DB, carry no
+// schema and reset search_path first when the connection was last used by a
+// domain, which costs a round trip. Driver errors are returned unwrapped so
+// database/sql can recognize ErrBadConn and ErrSkip.
+type searchPathConn struct {
+	driver.Conn
+	path string
+}
+
+func (c *searchPathConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	tx, err := c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &searchPathTx{Tx: tx, conn: c, prev: c.path}, nil
+}
+
+func (c *searchPathConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if vc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return vc.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+func (c *searchPathConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *searchPathConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+
+	return true
+}
+
+func (c *searchPathConn) Ping(ctx context.Context) error {
+	if err := c.use(ctx); err != nil {
+		return err
+	}
+
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// PrepareContext applies the schema when the statement is prepared, a
+// prepared statement runs with whatever search_path the connection has
+func (c *searchPathConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+
+	return c.Conn.Prepare(query)
+}
+
+func (c *searchPathConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *searchPathConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *searchPathConn) use(ctx context.Context) error {
+	schema, _ := ctx.Value(contextSchema).(string)
+
+	if schema == c.path {
+		return nil
+	}
+
+	// queries without a schema run against the server's default search_path
+	query := "RESET search_path"
+
+	if schema != "" {
+		query = fmt.Sprintf("SET search_path TO %s", quoteIdentifier(schema))
+	}
+
+	if _, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, nil); err != nil {
+		c.path = ""
+		return err
+	}
+
+	c.path = schema
+
+	return nil
+}
+
+// searchPathTx tracks search_path across a transaction. A SET inside it
+// lasts past a commit, but a rollback goes back to the path the connection
+// had when the transaction began.
+type searchPathTx struct {
+	driver.Tx
+	conn *searchPathConn
+	prev string
+}
+
+func (tx *searchPathTx) Rollback() error {
+	tx.conn.path = tx.prev
+
+	return tx.Tx.Rollback()
+}
+
+func quoteIdentifier(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(name, `"`, `""`))
+}
@@ -0,0 +1,175 @@
+package stdapp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"go.ddollar.dev/errors"
+)
+
+// recordConn is a driver connection that records the statements it is sent
+type recordConn struct {
+	statements *[]string
+}
+
+func (c recordConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c recordConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	*c.statements = append(*c.statements, "BEGIN")
+	return recordTx(c), nil
+}
+
+func (c recordConn) Close() error {
+	return nil
+}
+
+func (c recordConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	*c.statements = append(*c.statements, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c recordConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.Errorf("not supported")
+}
+
+func (c recordConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	*c.statements = append(*c.statements, query)
+	return recordRows{}, nil
+}
+
+type recordConnector struct {
+	conn recordConn
+}
+
+func (c recordConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.conn, nil
+}
+
+func (c recordConnector) Driver() driver.Driver {
+	return nil
+}
+
+type recordRows struct{}
+
+func (recordRows) Close() error                   { return nil }
+func (recordRows) Columns() []string              { return []string{} }
+func (recordRows) Next(dest []driver.Value) error { return io.EOF }
+
+type recordTx recordConn
+
+func (tx recordTx) Commit() error {
+	*tx.statements = append(*tx.statements, "COMMIT")
+	return nil
+}
+
+func (tx recordTx) Rollback() error {
+	*tx.statements = append(*tx.statements, "ROLLBACK")
+	return nil
+}
+
+func TestSearchPath(t *testing.T) {
+	rollback := errors.Errorf("rollback")
+
+	tests := []struct {
+		name       string
+		fn         func(ctx context.Context, root, acme *bun.DB) error
+		statements []string
+	}{
+		{
+			name: "domain then root",
+			fn: func(ctx context.Context, root, acme *bun.DB) error {
+				if _, err := acme.ExecContext(ctx, "SELECT 1"); err != nil {
+					return err
+				}
+				_, err := root.ExecContext(ctx, "SELECT 2")
+				return err
+			},
+			statements: []string{`SET search_path TO "app_acme"`, "SELECT 1", "RESET search_path", "SELECT 2"},
+		},
+		{
+			name: "same schema",
+			fn: func(ctx context.Context, root, acme *bun.DB) error {
+				for range 2 {
+					if _, err := acme.ExecContext(ctx, "SELECT 1"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			statements: []string{`SET search_path TO "app_acme"`, "SELECT 1", "SELECT 1"},
+		},
+		{
+			name: "domain rollback then root",
+			fn: func(ctx context.Context, root, acme *bun.DB) error {
+				err := acme.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+					if _, err := tx.ExecContext(ctx, "SELECT 1"); err != nil {
+						return err
+					}
+					return rollback
+				})
+				if !errors.Is(err, rollback) {
+					return err
+				}
+				_, err = root.ExecContext(ctx, "SELECT 2")
+				return err
+			},
+			statements: []string{`SET search_path TO "app_acme"`, "BEGIN", "SELECT 1", "ROLLBACK", "RESET search_path", "SELECT 2"},
+		},
+		{
+			name: "domain commit then domain",
+			fn: func(ctx context.Context, root, acme *bun.DB) error {
+				err := acme.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+					_, err := tx.ExecContext(ctx, "SELECT 1")
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				_, err = acme.ExecContext(ctx, "SELECT 2")
+				return err
+			},
+			statements: []string{`SET search_path TO "app_acme"`, "BEGIN", "SELECT 1", "COMMIT", "SELECT 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements := []string{}
+
+			a, err := New(Options{SchemaPrefix: "app_"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a single connection so that every statement shares its search_path
+			a.sqldb = sql.OpenDB(searchPathConnector{recordConnector{recordConn{statements: &statements}}})
+			a.sqldb.SetMaxOpenConns(1)
+			defer a.Close()
+
+			root, err := a.DB("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			acme, err := a.DB("acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.fn(context.Background(), root, acme); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(statements, tt.statements) {
+				t.Fatalf("expected %q, got %q", tt.statements, statements)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package stdapp
+
+import (
+	"io/fs"
+	"slices"
+	"time"
+
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/stdgraph"
+)
+
+// Feature is something a domain can turn on or off in DomainConfig
+type Feature string
+
+const (
+	FeatureGraphiQL      Feature = "graphiql"
+	FeatureIntrospection Feature = "introspection"
+	FeatureWebsocket     Feature = "websocket"
+)
+
+var features = []Feature{FeatureGraphiQL, FeatureIntrospection, FeatureWebsocket}
+
+// DomainConfig configures one domain, fields that are left empty fall back
+// to their Options. Middleware and GraphQLMiddleware run after those in
+// Options rather than replacing them. With DynamicDomains the config for
+// _domain applies to registered domains that don't have their own.
+type DomainConfig struct {
+	Features          map[Feature]bool
+	FieldCosts        map[string]FieldCost
+	GraphQLMiddleware []stdgraph.MiddlewareFunc
+	MaxComplexity     int
+	MaxDepth          int
+	Middleware        []Middleware
+	Migrations        fs.FS
+	OperationTimeout  time.Duration
+	Resolver          ResolverFunc
+}
+
+// domainConfig merges the config for a domain with Options, features that
+// the domain doesn't mention follow the app: GraphiQL in development or with
+// Options.GraphiQL, introspection and websockets always
+func (a *App) domainConfig(domain string) DomainConfig {
+	c, ok := a.opts.DomainConfig[domain]
+	if !ok && a.opts.DynamicDomains && !slices.Contains(a.staticDomains(), domain) {
+		c = a.opts.DomainConfig[domainMigrations]
+	}
+
+	dc := DomainConfig{
+		Features: map[Feature]bool{
+			FeatureGraphiQL:      a.graphiqlEnabled(),
+			FeatureIntrospection: true,
+			FeatureWebsocket:     true,
+		},
+		FieldCosts:        coalesce.Any(c.FieldCosts, a.opts.FieldCosts),
+		GraphQLMiddleware: append(append([]stdgraph.MiddlewareFunc{}, a.opts.GraphQLMiddleware...), c.GraphQLMiddleware...),
+		MaxComplexity:     coalesce.Any(c.MaxComplexity, a.opts.MaxComplexity),
+		MaxDepth:          coalesce.Any(c.MaxDepth, a.opts.MaxDepth),
+		Middleware:        c.Middleware,
+		Migrations:        c.Migrations,
+		OperationTimeout:  coalesce.Any(c.OperationTimeout, a.opts.OperationTimeout),
+		Resolver:          coalesce.Any(c.Resolver, a.opts.Resolver),
+	}
+
+	for f, on := range c.Features {
+		dc.Features[f] = on
+	}
+
+	return dc
+}
+
+// hasResolver is whether any domain serves GraphQL
+func (a *App) hasResolver() bool {
+	if a.opts.Resolver != nil {
+		return true
+	}
+
+	for _, c := range a.opts.DomainConfig {
+		if c.Resolver != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *App) validateDomainConfig() error {
+	for domain, c := range a.opts.DomainConfig {
+		if !a.opts.DynamicDomains && !slices.Contains(a.staticDomains(), domain) {
+			return errors.Errorf("domain config for unknown domain: %s", domain)
+		}
+
+		for f := range c.Features {
+			if !slices.Contains(features, f) {
+				return errors.Errorf("invalid feature for domain %s: %s", domain, f)
+			}
+		}
+	}
+
+	return nil
+}
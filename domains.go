@@ -0,0 +1,430 @@
+package stdapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/graphql-transport-ws/graphqlws"
+	"go.ddollar.dev/migrate"
+)
+
+const (
+	domainMigrations = "_domain"
+	domainsChannel   = "_domains"
+	domainsRefresh   = time.Minute
+)
+
+const domainsTable = `
+CREATE TABLE IF NOT EXISTS _domains (
+	name text PRIMARY KEY,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+`
+
+// domain names are used unquoted in urls and channel names so they are kept
+// to what postgres allows in an unquoted identifier
+var domainName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+type registeredDomain struct {
+	Created time.Time `bun:"created_at"`
+	Name    string    `bun:"name"`
+}
+
+// domains are those in Options.Domains followed by, with DynamicDomains,
+// those in the registry as of the last time it was loaded
+func (a *App) domains() []string {
+	domains := a.staticDomains()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, d := range a.registered {
+		if !slices.Contains(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+
+	return domains
+}
+
+func (a *App) staticDomains() []string {
+	return append([]string{}, coalesce.Any(a.opts.Domains, []string{"public"})...)
+}
+
+// loadDomains reads the registry, which doesn't exist until the first
+// migrate or domain create
+func (a *App) loadDomains(ctx context.Context) error {
+	if !a.opts.DynamicDomains {
+		return nil
+	}
+
+	rds, err := a.registeredDomains(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	names := []string{}
+
+	for _, rd := range rds {
+		names = append(names, rd.Name)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.registered = names
+
+	return nil
+}
+
+func (a *App) registeredDomains(ctx context.Context) ([]registeredDomain, error) {
+	db, err := a.DB("")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var exists bool
+
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('_domains') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	rds := []registeredDomain{}
+
+	if !exists {
+		return rds, nil
+	}
+
+	if err := db.NewSelect().Table("_domains").Column("name", "created_at").Order("name").Scan(ctx, &rds); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return rds, nil
+}
+
+// CreateDomain creates the schema for a new domain, runs its migrations and
+// adds it to the registry, running API replicas start serving it when they
+// are notified
+func (a *App) CreateDomain(ctx context.Context, name string) error {
+	if err := a.checkNewDomain(ctx, name); err != nil {
+		return errors.Wrap(err)
+	}
+
+	db, err := a.DB("")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quoteIdentifier(a.Schema(name)))); err != nil {
+		return errors.Wrap(err)
+	}
+
+	dsn, err := a.databaseURL()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := a.migrateDomain(ctx, u, name, migrate.Options{}); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := db.NewInsert().Table("_domains").Value("name", "?", name).Exec(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return a.domainsChanged(ctx)
+}
+
+// checkNewDomain makes sure name can be added to the registry, which it
+// creates if need be
+func (a *App) checkNewDomain(ctx context.Context, name string) error {
+	if !a.opts.DynamicDomains {
+		return errors.Errorf("dynamic domains are not enabled")
+	}
+
+	if !domainName.MatchString(name) {
+		return errors.Errorf("invalid domain name: %s", name)
+	}
+
+	// /api/graph is routed by DomainResolver ahead of the domain routes
+	if name == "graph" && a.opts.DomainResolver != nil {
+		return errors.Errorf("domain name is reserved: %s", name)
+	}
+
+	if len(a.Schema(name)) > 63 {
+		return errors.Errorf("domain name is too long: %s", name)
+	}
+
+	if err := a.prepare(ctx, ""); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if slices.Contains(a.domains(), name) {
+		return errors.Errorf("domain already exists: %s", name)
+	}
+
+	return nil
+}
+
+// DropDomain removes a domain from the registry and drops its schema along
+// with everything in it
+func (a *App) DropDomain(ctx context.Context, name string) error {
+	if !a.opts.DynamicDomains {
+		return errors.Errorf("dynamic domains are not enabled")
+	}
+
+	if slices.Contains(a.staticDomains(), name) {
+		return errors.Errorf("domain is not in the registry: %s", name)
+	}
+
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if !slices.Contains(a.domains(), name) {
+		return errors.Errorf("no such domain: %s", name)
+	}
+
+	db, err := a.DB("")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	// replicas stop serving the domain before its tables go away
+	if _, err := db.NewDelete().Table("_domains").Where("name = ?", name).Exec(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := a.domainsChanged(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", quoteIdentifier(a.Schema(name)))); err != nil {
+		return errors.Wrap(err)
+	}
+
+	a.forgetDomain(name)
+
+	return nil
+}
+
+func (a *App) domainsChanged(ctx context.Context) error {
+	db, err := a.DB("")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := db.ExecContext(ctx, "SELECT pg_notify(current_schema() || '.' || ?, '')", domainsChannel); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return a.loadDomains(ctx)
+}
+
+// forgetDomain closes what the app holds open for a domain that was dropped
+func (a *App) forgetDomain(name string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if n, ok := a.notifiers[name]; ok {
+		n.close() //nolint:errcheck
+		delete(a.notifiers, name)
+	}
+
+	delete(a.dbs, name)
+}
+
+// watchDomains reloads the registry when another process changes it, and
+// every so often in case a notification was missed while reconnecting
+func (a *App) watchDomains(ctx context.Context, changed func(ctx context.Context)) {
+	if !a.opts.DynamicDomains {
+		return
+	}
+
+	var ch <-chan string
+
+	if n, err := a.Notifier(ctx, ""); err != nil {
+		a.logger.At("domains").Logf("error=%q", err)
+	} else if ch, err = n.Subscribe(ctx, domainsChannel); err != nil {
+		a.logger.At("domains").Logf("error=%q", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-ch:
+			if !ok {
+				ch = nil
+			}
+		case <-time.After(domainsRefresh):
+		}
+
+		if err := a.loadDomains(ctx); err != nil {
+			a.logger.At("domains").Logf("error=%q", err)
+			continue
+		}
+
+		if changed != nil {
+			changed(ctx)
+		}
+	}
+}
+
+// domainRouter holds the GraphQL endpoints of every domain, it serves those
+// in the registry while static domains are served by their own routes, and
+// requests for any other path under /api fall through to the Router
+type domainRouter struct {
+	app       *App
+	endpoints map[string]*domainEndpoint
+	lock      sync.RWMutex
+	options   []graphqlws.Option
+	static    map[string]*domainEndpoint
+}
+
+type domainEndpoint struct {
+	graph    http.Handler
+	graphiql http.Handler
+}
+
+func (a *API) handleDomains(app *App, dr *domainRouter) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a.stop = cancel
+
+	if err := app.loadDomains(ctx); err != nil {
+		app.logger.At("domains").Logf("error=%q", err)
+	}
+
+	dr.refresh(ctx)
+
+	go app.watchDomains(ctx, dr.refresh)
+
+	a.server.Router.NewRoute().MatcherFunc(dr.match).Handler(app.WithMiddleware(dr))
+}
+
+func (dr *domainRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, rest := dr.path(r)
+
+	dr.lock.RLock()
+	e := dr.endpoints[name]
+	dr.lock.RUnlock()
+
+	e.serve(w, r, rest)
+}
+
+// serve handles a request to the endpoint with rest being the path after
+// the domain, a nil endpoint is one for a domain that isn't served
+func (e *domainEndpoint) serve(w http.ResponseWriter, r *http.Request, rest string) {
+	switch {
+	case e == nil:
+		http.NotFound(w, r)
+	case rest == "graphiql" && r.Method == "GET" && e.graphiql != nil:
+		e.graphiql.ServeHTTP(w, r)
+	default:
+		e.graph.ServeHTTP(w, r)
+	}
+}
+
+func (dr *domainRouter) match(r *http.Request, _ *mux.RouteMatch) bool {
+	name, _ := dr.path(r)
+
+	dr.lock.RLock()
+	defer dr.lock.RUnlock()
+
+	_, ok := dr.endpoints[name]
+
+	return ok
+}
+
+// endpoint finds the endpoint of a static or registered domain
+func (dr *domainRouter) endpoint(name string) (*domainEndpoint, bool) {
+	if e, ok := dr.static[name]; ok {
+		return e, true
+	}
+
+	dr.lock.RLock()
+	defer dr.lock.RUnlock()
+
+	e, ok := dr.endpoints[name]
+
+	return e, ok
+}
+
+func (dr *domainRouter) path(r *http.Request) (string, string) {
+	path, ok := strings.CutPrefix(r.URL.Path, fmt.Sprintf("%s/api/", dr.app.opts.Prefix))
+	if !ok {
+		return "", ""
+	}
+
+	name, rest, _ := strings.Cut(path, "/")
+
+	return name, rest
+}
+
+// refresh starts serving domains added to the registry and stops serving
+// those that were dropped, static domains are served by their own routes
+func (dr *domainRouter) refresh(ctx context.Context) {
+	static := dr.app.staticDomains()
+
+	dr.lock.RLock()
+	current := dr.endpoints
+	dr.lock.RUnlock()
+
+	endpoints := map[string]*domainEndpoint{}
+
+	for _, domain := range dr.app.domains() {
+		if slices.Contains(static, domain) {
+			continue
+		}
+
+		if e, ok := current[domain]; ok {
+			endpoints[domain] = e
+			continue
+		}
+
+		if dr.app.domainConfig(domain).Resolver == nil {
+			continue
+		}
+
+		e, err := dr.app.domainEndpoint(domain, dr.options...)
+		if err != nil {
+			dr.app.logger.At("domains").Logf("domain=%s error=%q", domain, err)
+			continue
+		}
+
+		endpoints[domain] = e
+
+		dr.app.logger.At("domains").Logf("domain=%s state=added", domain)
+	}
+
+	for domain := range current {
+		if _, ok := endpoints[domain]; !ok {
+			dr.app.forgetDomain(domain)
+			dr.app.logger.At("domains").Logf("domain=%s state=removed", domain)
+		}
+	}
+
+	dr.lock.Lock()
+	dr.endpoints = endpoints
+	dr.lock.Unlock()
+}
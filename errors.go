@@ -0,0 +1,182 @@
+package stdapp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"go.ddollar.dev/errors"
+)
+
+const maskedError = "internal server error"
+
+var contextRequestID = contextKey("request-id")
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "BAD_REQUEST",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "FORBIDDEN",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusConflict:            "CONFLICT",
+	http.StatusUnprocessableEntity: "BAD_USER_INPUT",
+	http.StatusTooManyRequests:     "RATE_LIMITED",
+}
+
+// ruleCodes tags the errors graphql-go's own validation returns for a limit
+// the same way as those stdapp returns
+var ruleCodes = map[string]string{
+	"MaxDepthEvaluationError": "DEPTH_LIMIT",
+	"MaxDepthExceeded":        "DEPTH_LIMIT",
+}
+
+// UserError is an error whose message is meant for the client and is never
+// masked, any error with an Extensions method is treated the same way
+type UserError struct {
+	Code    string
+	Message string
+}
+
+func UserErrorf(code, format string, args ...any) error {
+	return errors.Wrap(UserError{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e UserError) Error() string {
+	return e.Message
+}
+
+func (e UserError) Extensions() map[string]any {
+	return map[string]any{"code": e.Code}
+}
+
+type coder interface {
+	Code() int
+	Error() string
+}
+
+type extensioner interface {
+	Extensions() map[string]any
+	Error() string
+}
+
+// RequestID returns the id of the current GraphQL operation, taken from the
+// X-Request-Id header when the client sends one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextRequestID).(string)
+	return id
+}
+
+func newRequestID() string {
+	data := make([]byte, 8)
+
+	if _, err := rand.Read(data); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(data)
+}
+
+// graphErrors logs every error a resolver returned, masks those that aren't
+// meant for clients outside of development, and tags each with a code and
+// the request id
+func (g *graph) graphErrors(ctx context.Context, res *graphql.Response) {
+	for _, qe := range res.Errors {
+		if qe.Extensions == nil {
+			qe.Extensions = map[string]any{}
+		}
+
+		if qe.ResolverError != nil {
+			g.resolverError(ctx, qe)
+		}
+
+		if code, ok := ruleCodes[qe.Rule]; ok {
+			qe.Extensions["code"] = code
+		}
+
+		if _, ok := qe.Extensions["code"]; !ok {
+			qe.Extensions["code"] = "GRAPHQL_ERROR"
+		}
+
+		qe.Extensions["request_id"] = RequestID(ctx)
+	}
+}
+
+func (g *graph) resolverError(ctx context.Context, qe *gqlerrors.QueryError) {
+	for err := qe.ResolverError; err != nil; err = errors.Unwrap(err) {
+		switch t := err.(type) {
+		case extensioner:
+			for k, v := range t.Extensions() {
+				qe.Extensions[k] = v
+			}
+			qe.Message = t.Error()
+			return
+		case coder:
+			if code, ok := errorCodes[t.Code()]; ok {
+				qe.Extensions["code"] = code
+				qe.Message = t.Error()
+				return
+			}
+			if t.Code() < 500 {
+				qe.Extensions["code"] = "BAD_REQUEST"
+				qe.Message = t.Error()
+				return
+			}
+		}
+	}
+
+	g.logError(ctx, qe)
+
+	qe.Extensions["code"] = "INTERNAL_SERVER_ERROR"
+
+	if !g.app.opts.Development {
+		qe.Message = maskedError
+	}
+}
+
+// logError writes the error and then each frame recorded by errors.Wrap,
+// innermost first, all tagged so they can be found from the request id
+func (g *graph) logError(ctx context.Context, qe *gqlerrors.QueryError) {
+	log := g.app.logger.At("graphql").Append("domain=%s request=%s", g.domain, RequestID(ctx))
+
+	log.Logf("path=%q error=%q", errorPath(qe.Path), strings.ReplaceAll(qe.ResolverError.Error(), "\n", " "))
+
+	if et, ok := qe.ResolverError.(errors.ErrorTracer); ok {
+		for i, f := range et.ErrorTrace() {
+			log.Logf("frame=%d func=%s location=\"%v\"", i, f.Func, f)
+		}
+	}
+}
+
+func errorPath(path []any) string {
+	parts := make([]string, len(path))
+
+	for i := range path {
+		parts[i] = fmt.Sprint(path[i])
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// graphPanics stands in for graphql-go's panic logging and messages so that
+// panics are logged and masked like any other internal error
+type graphPanics struct {
+	graph *graph
+}
+
+func (p graphPanics) LogPanic(ctx context.Context, value any) {
+	p.graph.app.logger.At("graphql").Append("domain=%s request=%s", p.graph.domain, RequestID(ctx)).ErrorBacktrace(fmt.Errorf("panic: %v", value))
+}
+
+func (p graphPanics) MakePanicError(ctx context.Context, value any) *gqlerrors.QueryError {
+	qe := queryError("INTERNAL_SERVER_ERROR", "%s", maskedError)
+
+	if p.graph.app.opts.Development {
+		qe.Message = fmt.Sprintf("panic: %v", value)
+	}
+
+	return qe
+}
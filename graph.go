@@ -0,0 +1,395 @@
+package stdapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/graphql-transport-ws/graphqlws"
+	"go.ddollar.dev/stdgraph"
+)
+
+var (
+	contextAuthorization = contextKey("authorization")
+	contextDomain        = contextKey("domain")
+)
+
+// graph serves a domain's schema like stdgraph.Handler, but runs the GraphQL
+// middleware for every websocket operation as well as every POST so that
+// values from connection_init reach resolvers the same way headers do
+type graph struct {
+	app     *App
+	config  DomainConfig
+	domain  string
+	options []graphqlws.Option
+	queries *queries
+	schema  *graphql.Schema
+}
+
+func (a *App) graph(domain string, r Resolver, opts ...graphqlws.Option) (*graph, error) {
+	if err := checkStdgraphContext(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	q, err := a.queries()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	g := &graph{
+		app:     a,
+		config:  a.domainConfig(domain),
+		domain:  domain,
+		options: opts,
+		queries: q,
+	}
+
+	sopts := []graphql.SchemaOpt{graphql.Logger(graphPanics{g}), graphql.PanicHandler(graphPanics{g}), graphql.Tracer(graphTracer{})}
+
+	if g.config.MaxDepth > 0 {
+		sopts = append(sopts, graphql.MaxDepth(g.config.MaxDepth))
+	}
+
+	if !g.config.Features[FeatureIntrospection] {
+		sopts = append(sopts, graphql.DisableIntrospection())
+	}
+
+	schema, err := graphql.ParseSchema(r.Schema(), r, sopts...)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	g.schema = schema
+
+	return g, nil
+}
+
+func graphCORS(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Origin, X-Request-Id")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+}
+
+func (g *graph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	graphCORS(w)
+
+	switch r.Method {
+	case "GET", "POST":
+	case "OPTIONS":
+		fmt.Fprintf(w, "ok\n")
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		if !g.config.Features[FeatureWebsocket] {
+			http.Error(w, "websockets are not enabled for this domain", http.StatusBadRequest)
+			return
+		}
+
+		graphqlws.NewHandlerFunc(&graphSubscriber{graph: g, request: r}, http.HandlerFunc(g.exec), g.options...).ServeHTTP(w, r)
+		return
+	}
+
+	ctx, err := g.context(r.Context(), r)
+	switch t := errors.Cause(err).(type) {
+	case nil:
+	case stdgraph.Error:
+		http.Error(w, t.Error(), t.Code())
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	g.exec(w, r.WithContext(ctx))
+}
+
+type graphParams struct {
+	Extensions struct {
+		PersistedQuery *persistedQuery `json:"persistedQuery"`
+	} `json:"extensions"`
+	OperationName string         `json:"operationName"`
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (g *graph) exec(w http.ResponseWriter, r *http.Request) {
+	params, err := graphRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("X-Request-Id", RequestID(r.Context()))
+
+	ctx, ol := g.startOperation(r.Context(), params.OperationName, params.Variables)
+	defer ol.finish()
+
+	res := &graphql.Response{}
+
+	query, qerr := g.queries.resolve(ctx, params.Query, params.Extensions.PersistedQuery)
+	if qerr != nil {
+		res.Errors = append(res.Errors, qerr)
+	} else if op, qerr := g.operationLimits(query, params.OperationName, params.Variables); qerr != nil {
+		ol.operation(op)
+		res.Errors = append(res.Errors, qerr)
+	} else if r.Method == "GET" && op != nil && operationType(op) != "query" {
+		ol.operation(op)
+		res.Errors = append(res.Errors, queryError("BAD_REQUEST", "only queries can be sent with GET"))
+	} else {
+		ol.operation(op)
+
+		octx, cancel := g.operationContext(ctx, op)
+		defer cancel()
+
+		res = g.schema.Exec(octx, query, params.OperationName, params.Variables)
+
+		g.operationTimedOut(octx, res)
+	}
+
+	g.graphErrors(ctx, res)
+
+	ol.response(res)
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data) //nolint:errcheck
+}
+
+func (g *graph) context(ctx context.Context, r *http.Request) (context.Context, error) {
+	ctx = context.WithValue(ctx, contextApp, g.app)
+	ctx = context.WithValue(ctx, contextDomain, g.domain)
+	ctx = context.WithValue(ctx, contextLoaders, newLoaders())
+	ctx = context.WithValue(ctx, contextRequestID, coalesce.String(r.Header.Get("X-Request-Id"), newRequestID()))
+	ctx = context.WithValue(ctx, contextAuthorization, r.Header.Get("Authorization"))
+	ctx = stdgraphContext(ctx, r.Header.Get("Authorization"))
+
+	for _, fn := range g.config.GraphQLMiddleware {
+		c, err := fn(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx = c
+	}
+
+	return ctx, nil
+}
+
+// graphSubscriber runs each websocket operation as if it were a request made
+// with the upgrade request's headers plus those sent in connection_init
+type graphSubscriber struct {
+	graph   *graph
+	request *http.Request
+}
+
+func (s *graphSubscriber) Subscribe(ctx context.Context, document, operation string, variables map[string]any) (<-chan any, error) {
+	r := s.request.Clone(ctx)
+
+	if payload, ok := ctx.Value("Header").(json.RawMessage); ok {
+		mergeHeaders(r.Header, payload)
+	}
+
+	ctx, err := s.graph.context(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, ol := s.graph.startOperation(ctx, operation, variables)
+
+	// respond to a limit the way graphql-go responds to an invalid query
+	var op *ast.OperationDefinition
+	var qerr *gqlerrors.QueryError
+
+	if !s.graph.queries.allows(document) {
+		qerr = queryError("OPERATION_NOT_ALLOWED", "operation is not in the allow-list")
+	} else {
+		op, qerr = s.graph.operationLimits(document, operation, variables)
+	}
+
+	ol.operation(op)
+
+	if qerr != nil {
+		res := &graphql.Response{Errors: []*gqlerrors.QueryError{qerr}}
+		s.graph.graphErrors(ctx, res)
+
+		ol.response(res)
+		ol.finish()
+
+		ch := make(chan any, 1)
+		ch <- res
+		close(ch)
+		return ch, nil
+	}
+
+	octx, cancel := s.graph.operationContext(ctx, op)
+
+	ch, err := s.graph.schema.Subscribe(octx, document, operation, variables)
+	if err != nil {
+		cancel()
+		ol.errors++
+		ol.finish()
+		return nil, err
+	}
+
+	out := make(chan any)
+
+	// forward until the connection goes away rather than until the operation
+	// deadline so that a timed out response still reaches the client
+	go func() {
+		defer ol.finish()
+		defer cancel()
+		defer close(out)
+
+		for res := range ch {
+			if r, ok := res.(*graphql.Response); ok {
+				s.graph.operationTimedOut(octx, r)
+				s.graph.graphErrors(octx, r)
+				ol.response(r)
+			}
+
+			// each event is resolved afresh
+			if ls, ok := octx.Value(contextLoaders).(*loaders); ok {
+				ls.reset()
+			}
+
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// graphRequest reads a POST body or, so that persisted queries can be cached
+// by their url, the equivalent GET parameters
+func graphRequest(r *http.Request) (*graphParams, error) {
+	params := &graphParams{}
+
+	if r.Method != "GET" {
+		if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		return params, nil
+	}
+
+	q := r.URL.Query()
+
+	params.OperationName = q.Get("operationName")
+	params.Query = q.Get("query")
+
+	for name, v := range map[string]any{"extensions": &params.Extensions, "variables": &params.Variables} {
+		if s := q.Get(name); s != "" {
+			if err := json.Unmarshal([]byte(s), v); err != nil {
+				return nil, errors.Errorf("invalid %s: %s", name, err)
+			}
+		}
+	}
+
+	return params, nil
+}
+
+// Authorization returns the credentials of the given kind, such as "Bearer",
+// from the Authorization header or connection_init payload of the operation
+func Authorization(ctx context.Context, kind string) string {
+	prefix := fmt.Sprintf("%s ", kind)
+
+	if v, ok := ctx.Value(contextAuthorization).(string); ok && strings.HasPrefix(v, prefix) {
+		return v[len(prefix):]
+	}
+
+	return ""
+}
+
+// stdgraphContext adds the Authorization header under stdgraph's own key, so
+// that resolvers written for stdgraph.Handler can still call
+// stdgraph.Authorization. stdgraph doesn't export its key and only sets it
+// in ServeHTTP, before running the middleware added with Use, so the context
+// is taken from the first middleware of a Handler that serves nothing else.
+// checkStdgraphContext stops a graph from starting if a stdgraph release
+// changes that.
+func stdgraphContext(ctx context.Context, authorization string) context.Context {
+	var sctx context.Context
+
+	h := &stdgraph.Handler{}
+
+	h.Use(func(c context.Context, r *http.Request) (context.Context, error) {
+		sctx = c
+		return nil, errStdgraphContext
+	})
+
+	r := (&http.Request{Method: "POST", Header: http.Header{"Authorization": {authorization}}}).WithContext(ctx)
+
+	h.ServeHTTP(discardResponse{}, r)
+
+	if sctx == nil {
+		return ctx
+	}
+
+	return sctx
+}
+
+func checkStdgraphContext() error {
+	if stdgraph.Authorization(stdgraphContext(context.Background(), "Bearer check"), "Bearer") != "check" {
+		return errors.Errorf("this version of stdgraph can not be given the Authorization for its resolvers")
+	}
+
+	return nil
+}
+
+var errStdgraphContext = stdgraph.Errorf(http.StatusOK, "context captured")
+
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (discardResponse) WriteHeader(int)             {}
+
+// Domain returns the domain serving the current GraphQL operation
+func Domain(ctx context.Context) string {
+	domain, _ := ctx.Value(contextDomain).(string)
+	return domain
+}
+
+// mergeHeaders accepts string values at the top level of a connection_init
+// payload or within a "headers" object, the two shapes clients commonly send
+func mergeHeaders(h http.Header, payload json.RawMessage) {
+	var values map[string]any
+
+	if err := json.Unmarshal(payload, &values); err != nil {
+		return
+	}
+
+	if nested, ok := values["headers"].(map[string]any); ok {
+		for k, v := range nested {
+			values[k] = v
+		}
+	}
+
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			h.Set(k, s)
+		}
+	}
+}
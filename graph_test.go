@@ -0,0 +1,80 @@
+package stdapp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.ddollar.dev/stdgraph"
+)
+
+func TestGraphContextAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		kind   string
+		token  string
+	}{
+		{name: "bearer", header: "Bearer abc", kind: "Bearer", token: "abc"},
+		{name: "other kind", header: "Basic abc", kind: "Bearer"},
+		{name: "missing", kind: "Bearer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &graph{app: &App{}, domain: "public"}
+
+			r, err := http.NewRequest("POST", "/api/graph", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			ctx, err := g.context(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if token := Authorization(ctx, tt.kind); token != tt.token {
+				t.Fatalf("expected stdapp.Authorization to be %q, got %q", tt.token, token)
+			}
+
+			if token := stdgraph.Authorization(ctx, tt.kind); token != tt.token {
+				t.Fatalf("expected stdgraph.Authorization to be %q, got %q", tt.token, token)
+			}
+
+			if domain := Domain(ctx); domain != "public" {
+				t.Fatalf("expected domain public, got %q", domain)
+			}
+		})
+	}
+}
+
+func TestMergeHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		expected string
+	}{
+		{name: "top level", payload: `{"Authorization": "Bearer a"}`, expected: "Bearer a"},
+		{name: "nested", payload: `{"headers": {"Authorization": "Bearer b"}}`, expected: "Bearer b"},
+		{name: "not a string", payload: `{"Authorization": 1}`, expected: "Bearer upgrade"},
+		{name: "invalid", payload: `[`, expected: "Bearer upgrade"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{"Authorization": {"Bearer upgrade"}}
+
+			mergeHeaders(h, json.RawMessage(tt.payload))
+
+			if v := h.Get("Authorization"); v != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package stdapp
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed graphiql.html
+var graphiqlPage string
+
+var graphiqlTemplate = template.Must(template.New("graphiql").Parse(graphiqlPage))
+
+func (a *App) graphiqlEnabled() bool {
+	return a.opts.Development || a.opts.GraphiQL
+}
+
+func (a *App) graphiql(domain, endpoint string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		params := map[string]string{
+			"Domain":   domain,
+			"Endpoint": endpoint,
+			"Name":     a.opts.Name,
+		}
+
+		if err := graphiqlTemplate.Execute(w, params); err != nil {
+			a.logger.At("graphiql").Error(err) //nolint:errcheck
+		}
+	}
+}
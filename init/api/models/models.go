@@ -99,16 +99,6 @@ func (m *Models) Save(v any, columns ...string) error {
 	return nil
 }
 
-func (m *Models) TableChanged(ctx context.Context, name string) <-chan string {
-	ch := make(chan string)
-
-	go m.listen(ctx, name, func(id string) {
-		ch <- id
-	})
-
-	return ch
-}
-
 func (m *Models) transaction(fn func(*Models) error) error {
 	db, ok := m.db.(*pg.DB)
 	if !ok {
@@ -148,27 +138,6 @@ func (m *Models) updateColumns(v interface{}, additional ...string) string {
 	return strings.Join(statements, ",")
 }
 
-func (m *Models) listen(ctx context.Context, channel string, fn func(string)) {
-	db, ok := m.db.(*pg.DB)
-	if !ok {
-		fmt.Println("error: listen unsupported on model db")
-	}
-
-	ln := db.Listen(ctx, channel)
-	defer ln.Close()
-
-	ch := ln.Channel()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg := <-ch:
-			fn(msg.Payload)
-		}
-	}
-}
-
 func modelTags(v interface{}) map[string]map[string]bool {
 	tags := map[string]map[string]bool{}
 
@@ -1,5 +1,8 @@
 package resolver
 
+import "go.ddollar.dev/stdapp"
+
 type Subscription struct {
+	stdapp.TableChanged
 	r *Resolver
 }
@@ -1,7 +1,7 @@
 CREATE
 OR REPLACE FUNCTION table_changed () RETURNS TRIGGER AS $$
 BEGIN
-        PERFORM pg_notify(TG_ARGV[0], CASE TG_OP
+        PERFORM pg_notify(TG_TABLE_SCHEMA || '.' || TG_ARGV[0], CASE TG_OP
         WHEN 'INSERT' THEN NEW.ctid
         WHEN 'UPDATE' THEN NEW.ctid
         ELSE OLD.ctid
@@ -34,12 +34,15 @@ func app() (*stdapp.App, error) {
 	}
 
 	opts := stdapp.Options{
-		Database:     os.Getenv("DATABASE_URL"),
-		Migrations:   migrations,
-		Name:         "stdapp-init",
-		Resolver:     resolver.New,
-		Web:          sweb,
-		WriteTimeout: 5 * time.Minute,
+		DomainResolver: stdapp.DomainDefault("public"),
+		Migrations:     migrations,
+		Name:           "stdapp-init",
+		Resolver:       resolver.New,
+		Web:            sweb,
+		WriteTimeout:   5 * time.Minute,
+
+		// only accept the queries the web client uses, written by make build
+		// QueryAllowList: "queries.json",
 	}
 
 	a, err := stdapp.New(opts)
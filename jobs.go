@@ -0,0 +1,413 @@
+package stdapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+)
+
+const (
+	jobAttempts    = 10
+	jobConcurrency = 10
+	jobPoll        = time.Second
+	jobTimeout     = 5 * time.Minute
+)
+
+const jobsTable = `
+CREATE TABLE IF NOT EXISTS _jobs (
+	id bigserial PRIMARY KEY,
+	kind text NOT NULL,
+	args jsonb NOT NULL DEFAULT '{}',
+	state text NOT NULL DEFAULT 'available',
+	attempts integer NOT NULL DEFAULT 0,
+	max_attempts integer NOT NULL,
+	unique_key text,
+	last_error text,
+	run_at timestamptz NOT NULL DEFAULT now(),
+	locked_at timestamptz,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	finished_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS _jobs_available ON _jobs (run_at, id) WHERE state = 'available';
+
+CREATE UNIQUE INDEX IF NOT EXISTS _jobs_unique ON _jobs (kind, unique_key) WHERE unique_key IS NOT NULL AND state IN ('available', 'running');
+`
+
+type Job struct {
+	Args        json.RawMessage
+	Attempt     int
+	DB          *bun.DB
+	Domain      string
+	ID          int64
+	Kind        string
+	MaxAttempts int
+}
+
+// JobFunc runs a job. Delivery is at least once: a job is deleted after its
+// JobFunc returns, outside of any transaction the JobFunc used, so a worker
+// that dies in between leaves the job to run again once it is reclaimed.
+// Handlers with side effects should be safe to repeat.
+type JobFunc func(ctx context.Context, job *Job) error
+
+// JobKind ties a job name to the type of its arguments
+type JobKind[T any] string
+
+type JobOptions struct {
+	MaxAttempts int
+	RunAt       time.Time
+	UniqueKey   string
+}
+
+// Enqueue inserts a job using db, which may be a transaction so that the job
+// is only visible to workers once the caller commits. That makes enqueueing
+// part of the caller's transaction, not running the job, which may happen
+// more than once. A job whose UniqueKey matches one that is still available
+// or running is silently dropped.
+func Enqueue(ctx context.Context, db bun.IDB, kind string, args any, opts JobOptions) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	var runAt, unique any
+
+	if !opts.RunAt.IsZero() {
+		runAt = opts.RunAt
+	}
+
+	if opts.UniqueKey != "" {
+		unique = opts.UniqueKey
+	}
+
+	_, err = db.NewRaw(`
+		INSERT INTO _jobs (kind, args, max_attempts, run_at, unique_key)
+		VALUES (?, ?::jsonb, ?, coalesce(?::timestamptz, now()), ?)
+		ON CONFLICT (kind, unique_key) WHERE unique_key IS NOT NULL AND state IN ('available', 'running') DO NOTHING
+	`, kind, string(data), coalesce.Any(opts.MaxAttempts, jobAttempts), runAt, unique).Exec(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (j *Job) Decode(v any) error {
+	if err := json.Unmarshal(j.Args, v); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (k JobKind[T]) Enqueue(ctx context.Context, db bun.IDB, args T, opts JobOptions) error {
+	return Enqueue(ctx, db, string(k), args, opts)
+}
+
+func (k JobKind[T]) Handler(fn func(ctx context.Context, job *Job, args T) error) JobFunc {
+	return func(ctx context.Context, job *Job) error {
+		var args T
+
+		if err := job.Decode(&args); err != nil {
+			return errors.Wrap(err)
+		}
+
+		return fn(ctx, job, args)
+	}
+}
+
+// work runs jobs until ctx is cancelled then waits up to drain for running
+// jobs to finish before cancelling them
+func (a *App) work(ctx context.Context, concurrency int, drain time.Duration) error {
+	kinds := []string{}
+
+	for kind := range a.opts.Jobs {
+		kinds = append(kinds, kind)
+	}
+
+	if len(kinds) == 0 {
+		return errors.Errorf("no jobs configured")
+	}
+
+	sort.Strings(kinds)
+
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	go a.watchDomains(ctx, nil)
+
+	for _, domain := range a.domains() {
+		if err := a.prepare(ctx, domain); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	a.logger.At("worker").Logf("concurrency=%d kinds=%q", concurrency, kinds)
+
+	jctx, jcancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer jcancel()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			a.workLoop(ctx, jctx, kinds)
+		}()
+	}
+
+	go a.reclaimLoop(ctx)
+
+	<-ctx.Done()
+
+	a.logger.At("worker").Logf("state=draining timeout=%s", drain)
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(drain):
+		jcancel()
+		<-done
+		return errors.Errorf("jobs still running after %s", drain)
+	}
+
+	a.logger.At("worker").Logf("state=complete")
+
+	return nil
+}
+
+func (a *App) workLoop(ctx, jctx context.Context, kinds []string) {
+	for ctx.Err() == nil {
+		found := false
+
+		for _, domain := range a.domains() {
+			ok, err := a.runJob(ctx, jctx, domain, kinds)
+			if err != nil && ctx.Err() == nil {
+				a.logger.At("job").Logf("domain=%s error=%q", domain, err)
+			}
+
+			found = found || ok
+		}
+
+		if !found {
+			select {
+			case <-ctx.Done():
+			case <-time.After(jobPoll):
+			}
+		}
+	}
+}
+
+// runJob claims and runs at most one job, claiming stops with ctx while
+// running and recording the result use jctx
+func (a *App) runJob(ctx, jctx context.Context, domain string, kinds []string) (bool, error) {
+	db, err := a.DB(domain)
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+
+	job := &Job{DB: db, Domain: domain}
+
+	err = db.QueryRowContext(ctx, `
+		UPDATE _jobs SET state = 'running', attempts = attempts + 1, locked_at = now()
+		WHERE id = (
+			SELECT id FROM _jobs
+			WHERE state = 'available' AND run_at <= now() AND kind IN (?)
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, kind, args, attempts, max_attempts
+	`, bun.In(kinds)).Scan(&job.ID, &job.Kind, &job.Args, &job.Attempt, &job.MaxAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+
+	log := a.logger.At("job").Append("domain=%s id=%d kind=%s attempt=%d", domain, job.ID, job.Kind, job.Attempt).Start()
+
+	if err := a.callJob(jctx, job); err != nil {
+		return true, a.failJob(jctx, job, log.Error(err))
+	}
+
+	log.Success() //nolint:errcheck
+
+	if _, err := db.ExecContext(jctx, "DELETE FROM _jobs WHERE id = ?", job.ID); err != nil {
+		return true, errors.Wrap(err)
+	}
+
+	return true, nil
+}
+
+func (a *App) callJob(ctx context.Context, job *Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, coalesce.Any(a.opts.JobTimeout, jobTimeout))
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+
+	return a.opts.Jobs[job.Kind](ctx, job)
+}
+
+func (a *App) failJob(ctx context.Context, job *Job, jerr error) error {
+	state := "available"
+	runAt := time.Now().Add(jobBackoff(job.Attempt))
+
+	var finished any
+
+	if job.Attempt >= job.MaxAttempts {
+		state = "dead"
+		finished = time.Now()
+	}
+
+	_, err := job.DB.ExecContext(ctx, `
+		UPDATE _jobs SET state = ?, run_at = ?, locked_at = NULL, last_error = ?, finished_at = ?
+		WHERE id = ?
+	`, state, runAt, jerr.Error(), finished, job.ID)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// reclaimLoop releases jobs held by workers that died without recording a result
+func (a *App) reclaimLoop(ctx context.Context) {
+	timeout := 2 * coalesce.Any(a.opts.JobTimeout, jobTimeout)
+
+	for {
+		for _, domain := range a.domains() {
+			if err := a.reclaimJobs(ctx, domain, timeout); err != nil && ctx.Err() == nil {
+				a.logger.At("reclaim").Logf("domain=%s error=%q", domain, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (a *App) reclaimJobs(ctx context.Context, domain string, timeout time.Duration) error {
+	db, err := a.DB(domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE _jobs SET
+			state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'available' END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN now() END,
+			locked_at = NULL,
+			last_error = 'lock expired'
+		WHERE state = 'running' AND locked_at < now() - ? * interval '1 second'
+	`, int(timeout.Seconds()))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+type deadJob struct {
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	Finished  time.Time `json:"finished"`
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	UniqueKey string    `json:"unique_key,omitempty"`
+}
+
+func (a *App) deadJobs(ctx context.Context, domain string) ([]deadJob, error) {
+	if len(a.opts.Jobs) == 0 {
+		return nil, errors.Errorf("no jobs configured")
+	}
+
+	db, err := a.DB(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	jobs := []deadJob{}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, kind, attempts, coalesce(unique_key, ''), coalesce(last_error, ''), finished_at
+		FROM _jobs WHERE state = 'dead' ORDER BY finished_at DESC
+	`)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var j deadJob
+
+		if err := rows.Scan(&j.ID, &j.Kind, &j.Attempts, &j.UniqueKey, &j.Error, &j.Finished); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return jobs, nil
+}
+
+// retryJob makes a dead job available again with a fresh set of attempts
+func (a *App) retryJob(ctx context.Context, domain string, id int64) error {
+	if len(a.opts.Jobs) == 0 {
+		return errors.Errorf("no jobs configured")
+	}
+
+	db, err := a.DB(domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	res, err := db.ExecContext(ctx, `
+		UPDATE _jobs SET state = 'available', attempts = 0, run_at = now(), finished_at = NULL
+		WHERE id = ? AND state = 'dead'
+	`, id)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err)
+	} else if n == 0 {
+		return errors.Errorf("no dead job: %d", id)
+	}
+
+	return nil
+}
+
+// jobBackoff grows polynomially from 15s to a few hours by the tenth attempt
+func jobBackoff(attempt int) time.Duration {
+	return time.Duration(attempt*attempt*attempt*attempt)*time.Second + 15*time.Second
+}
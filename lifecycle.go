@@ -0,0 +1,72 @@
+package stdapp
+
+import (
+	"context"
+
+	"go.ddollar.dev/errors"
+)
+
+type HookFunc func(ctx context.Context, a *App) error
+
+type ShutdownFunc func(ctx context.Context) error
+
+func (a *App) Domains() []string {
+	return a.domains()
+}
+
+func (a *App) start(ctx context.Context) error {
+	if a.opts.OnStart == nil {
+		return nil
+	}
+
+	a.logger.At("start").Logf("hook=OnStart")
+
+	if err := a.opts.OnStart(ctx, a); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) ready(ctx context.Context) error {
+	if a.opts.OnReady == nil {
+		return nil
+	}
+
+	a.logger.At("ready").Logf("hook=OnReady")
+
+	if err := a.opts.OnReady(ctx, a); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) shutdown(ctx context.Context) error {
+	if a.opts.OnShutdown == nil {
+		return nil
+	}
+
+	a.logger.At("shutdown").Logf("hook=OnShutdown")
+
+	if err := a.opts.OnShutdown(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// lifecycle runs fn between the start, ready and shutdown hooks for commands that don't listen
+func (a *App) lifecycle(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := a.start(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	err := a.ready(ctx)
+
+	if err == nil {
+		err = fn(ctx)
+	}
+
+	return errors.Join(err, a.shutdown(context.WithoutCancel(ctx)))
+}
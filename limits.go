@@ -0,0 +1,214 @@
+package stdapp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+)
+
+// FieldCost is the cost of resolving a field, keyed by "Type.field" in
+// Options.FieldCosts. The cost of the field's selections is multiplied by the
+// value of each Multipliers argument present, such as the page size of a list.
+type FieldCost struct {
+	Cost        int
+	Multipliers []string
+}
+
+// operationLimits measures an operation against MaxComplexity with the
+// document as graphql-go parses it, MaxDepth is checked by graphql-go while it
+// validates the operation. With either limit set, an operation that can't be
+// measured is rejected rather than run unchecked.
+func (g *graph) operationLimits(document, operation string, variables map[string]any) (*ast.OperationDefinition, *gqlerrors.QueryError) {
+	doc, perr := parseQuery(document)
+
+	var op *ast.OperationDefinition
+
+	if perr == nil {
+		op = queryOperation(doc, operation)
+	}
+
+	if op == nil {
+		reason := "the operation to run is ambiguous"
+		if perr != nil {
+			reason = perr.Message
+		}
+
+		switch {
+		case g.config.MaxDepth > 0:
+			return nil, queryError("DEPTH_LIMIT", "could not measure query depth: %s", reason)
+		case g.config.MaxComplexity > 0:
+			return nil, queryError("COMPLEXITY_LIMIT", "could not measure query complexity: %s", reason)
+		}
+
+		return nil, nil
+	}
+
+	if max := g.config.MaxComplexity; max > 0 {
+		root := g.schema.ASTSchema().RootOperationTypes[operationType(op)]
+		if root == nil {
+			return op, queryError("COMPLEXITY_LIMIT", "could not measure query complexity: schema has no %s type", operationType(op))
+		}
+
+		c := &complexity{costs: g.config.FieldCosts, doc: doc, schema: g.schema.ASTSchema(), variables: variables}
+
+		if cost := c.selections(root.TypeName(), op.Selections, map[string]bool{}); cost > max {
+			return op, queryError("COMPLEXITY_LIMIT", "query complexity %d exceeds the maximum of %d", cost, max)
+		}
+	}
+
+	return op, nil
+}
+
+// operationContext applies OperationTimeout to queries and mutations, the
+// deadline reaches bun through the context passed to resolvers and the
+// batches of their loaders
+func (g *graph) operationContext(ctx context.Context, op *ast.OperationDefinition) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+
+	if g.config.OperationTimeout <= 0 || (op != nil && operationType(op) == "subscription") {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, g.config.OperationTimeout)
+	}
+
+	if ls, ok := ctx.Value(contextLoaders).(*loaders); ok {
+		ls.bind(ctx)
+	}
+
+	return ctx, cancel
+}
+
+// operationTimedOut adds an error saying why resolvers failed when the
+// deadline expired, which they otherwise report as a cancelled query
+func (g *graph) operationTimedOut(ctx context.Context, res *graphql.Response) {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return
+	}
+
+	res.Errors = append(res.Errors, queryError("TIMEOUT", "operation exceeded the timeout of %s", g.config.OperationTimeout.Round(time.Millisecond)))
+}
+
+func queryError(code, format string, args ...any) *gqlerrors.QueryError {
+	return &gqlerrors.QueryError{
+		Message:    fmt.Sprintf(format, args...),
+		Extensions: map[string]any{"code": code},
+	}
+}
+
+type complexity struct {
+	costs     map[string]FieldCost
+	doc       *ast.ExecutableDefinition
+	schema    *ast.Schema
+	variables map[string]any
+}
+
+// selections doesn't count introspection, which costs the same whatever
+// the schema
+func (c *complexity) selections(parent string, sels ast.SelectionSet, visited map[string]bool) int {
+	total := 0
+
+	for _, sel := range sels {
+		switch s := sel.(type) {
+		case *ast.Field:
+			if !strings.HasPrefix(s.Name.Name, "__") {
+				total += c.field(parent, s, visited)
+			}
+		case *ast.FragmentSpread:
+			if f := c.doc.Fragments.Get(s.Name.Name); f != nil && !visited[s.Name.Name] {
+				visited[s.Name.Name] = true
+				total += c.selections(f.On.Name, f.Selections, visited)
+				delete(visited, s.Name.Name)
+			}
+		case *ast.InlineFragment:
+			total += c.selections(coalesce.String(s.On.Name, parent), s.Selections, visited)
+		}
+	}
+
+	return total
+}
+
+func (c *complexity) field(parent string, s *ast.Field, visited map[string]bool) int {
+	fc, ok := c.costs[fmt.Sprintf("%s.%s", parent, s.Name.Name)]
+	if !ok {
+		fc = FieldCost{Cost: 1}
+	}
+
+	if len(s.SelectionSet) == 0 {
+		return fc.Cost
+	}
+
+	multiplier := 1
+
+	for _, name := range fc.Multipliers {
+		if v, ok := s.Arguments.Get(name); ok {
+			if n := c.argument(v); n > 0 {
+				multiplier *= n
+			}
+		}
+	}
+
+	return fc.Cost + multiplier*c.selections(c.fieldType(parent, s.Name.Name), s.SelectionSet, visited)
+}
+
+// argument reads a literal itself rather than through Deserialize, which
+// panics on an Int that graphql-go's validation would go on to reject
+func (c *complexity) argument(v ast.Value) int {
+	switch t := v.(type) {
+	case *ast.Variable:
+		switch n := c.variables[t.Name].(type) {
+		case float64:
+			return int(n)
+		case int:
+			return n
+		}
+	case *ast.PrimitiveValue:
+		if t.Type == scanner.Int {
+			n, _ := strconv.Atoi(t.Text)
+			return n
+		}
+	}
+
+	return 0
+}
+
+// fieldType is the named type of a field with lists and non-null unwrapped,
+// or "" when the schema doesn't have the field
+func (c *complexity) fieldType(parent, name string) string {
+	var fields ast.FieldsDefinition
+
+	switch t := c.schema.Types[parent].(type) {
+	case *ast.ObjectTypeDefinition:
+		fields = t.Fields
+	case *ast.InterfaceTypeDefinition:
+		fields = t.Fields
+	}
+
+	f := fields.Get(name)
+	if f == nil {
+		return ""
+	}
+
+	t := f.Type
+
+	for {
+		switch w := t.(type) {
+		case *ast.List:
+			t = w.OfType
+		case *ast.NonNull:
+			t = w.OfType
+		case ast.NamedType:
+			return w.TypeName()
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package stdapp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+const limitsSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	a: A
+	items(first: Int): [A!]!
+}
+
+type Mutation {
+	touch: A
+}
+
+type A {
+	b: A
+	c: Int
+}
+`
+
+func limitsGraph(t *testing.T, config DomainConfig) *graph {
+	t.Helper()
+
+	opts := []graphql.SchemaOpt{}
+
+	if config.MaxDepth > 0 {
+		opts = append(opts, graphql.MaxDepth(config.MaxDepth))
+	}
+
+	schema, err := graphql.ParseSchema(limitsSchema, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &graph{config: config, schema: schema}
+}
+
+func TestOperationComplexity(t *testing.T) {
+	costs := map[string]FieldCost{
+		"Query.items": {Cost: 2, Multipliers: []string{"first"}},
+		"A.b":         {Cost: 3},
+	}
+
+	tests := []struct {
+		name      string
+		document  string
+		operation string
+		variables map[string]any
+		max       int
+		code      string
+	}{
+		{name: "under", document: "{ a { c } }", max: 2},
+		{name: "over", document: "{ a { b { c } } }", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "at", document: "{ a { b { c } } }", max: 5},
+		{name: "multiplier inline", document: "{ items(first: 10) { c } }", max: 12},
+		{name: "multiplier over", document: "{ items(first: 10) { c } }", max: 11, code: "COMPLEXITY_LIMIT"},
+		{name: "multiplier variable", document: "query($n: Int) { items(first: $n) { c } }", variables: map[string]any{"n": float64(10)}, max: 11, code: "COMPLEXITY_LIMIT"},
+		{name: "multiplier out of range", document: "{ items(first: 99999999999) { c } }", max: 3, code: "COMPLEXITY_LIMIT"},
+		{name: "fragment", document: "{ a { ...F } } fragment F on A { b { c } }", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "inline fragment", document: "{ a { ... on A { b { c } } } }", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "cyclic fragment", document: "{ a { ...F } } fragment F on A { b { ...F } }", max: 100},
+		{name: "introspection free", document: "{ __schema { types { name } } a { c } }", max: 2},
+		{name: "mutation", document: "mutation { touch { b { c } } }", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "named operation", document: "query X { a { c } } query Y { a { b { c } } }", operation: "Y", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "byte order mark", document: "\uFEFF{ a { b { b { c } } } }", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "unicode alias", document: "{ é: a { b { b { c } } } }", max: 4, code: "COMPLEXITY_LIMIT"},
+		{name: "syntax error", document: "{ a { c }", max: 100, code: "COMPLEXITY_LIMIT"},
+		{name: "ambiguous", document: "query X { a { c } } query Y { a { c } }", max: 100, code: "COMPLEXITY_LIMIT"},
+		{name: "unknown operation", document: "query X { a { c } }", operation: "Y", max: 100, code: "COMPLEXITY_LIMIT"},
+		{name: "unlimited syntax error", document: "{ a { c }"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := limitsGraph(t, DomainConfig{FieldCosts: costs, MaxComplexity: tt.max})
+
+			_, qerr := g.operationLimits(tt.document, tt.operation, tt.variables)
+
+			code := ""
+			if qerr != nil {
+				code, _ = qerr.Extensions["code"].(string)
+			}
+
+			if code != tt.code {
+				t.Fatalf("expected code %q, got %q (%v)", tt.code, code, qerr)
+			}
+		})
+	}
+}
+
+func TestOperationDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		code     string
+	}{
+		{name: "under", document: "{ a { b { c } } }"},
+		{name: "over", document: "{ a { b { b { c } } } }", code: "DEPTH_LIMIT"},
+		{name: "fragment", document: "{ a { ...F } } fragment F on A { b { b { c } } }", code: "DEPTH_LIMIT"},
+		{name: "byte order mark", document: "\uFEFF{ a { b { b { c } } } }", code: "DEPTH_LIMIT"},
+		{name: "unicode alias", document: "{ é: a { b { b { c } } } }", code: "DEPTH_LIMIT"},
+		{name: "syntax error", document: "{ a { b { b { c } } }", code: "DEPTH_LIMIT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := limitsGraph(t, DomainConfig{MaxDepth: 3})
+
+			_, qerr := g.operationLimits(tt.document, "", nil)
+			if qerr != nil {
+				if code := qerr.Extensions["code"]; code != tt.code {
+					t.Fatalf("expected code %q, got %q", tt.code, code)
+				}
+				return
+			}
+
+			res := &graphql.Response{Errors: g.schema.Validate(tt.document)}
+
+			g.graphErrors(context.Background(), res)
+
+			code := ""
+			if len(res.Errors) > 0 {
+				code, _ = res.Errors[0].Extensions["code"].(string)
+			}
+
+			if code != tt.code {
+				t.Fatalf("expected code %q, got %q (%v)", tt.code, code, res.Errors)
+			}
+		})
+	}
+}
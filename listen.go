@@ -0,0 +1,131 @@
+package stdapp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/stdapi"
+	"go.ddollar.dev/stdcli"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+type listenOptions struct {
+	Addr             string
+	CertificateCache string
+	CertificateFile  string
+	Drain            time.Duration
+	KeyFile          string
+	Protocol         string
+}
+
+func (a *App) listenOptions(ctx stdcli.Context) listenOptions {
+	return listenOptions{
+		Addr:             fmt.Sprintf(":%d", coalesce.Any(ctx.Flags().Int("port"), a.opts.Port, 8000)),
+		CertificateCache: coalesce.String(ctx.Flags().String("cert-cache"), a.opts.CertificateCache),
+		CertificateFile:  coalesce.String(ctx.Flags().String("cert"), a.opts.CertificateFile),
+		Drain:            coalesce.Any(flagDuration(ctx, "drain"), a.opts.ShutdownTimeout, shutdownTimeout),
+		KeyFile:          coalesce.String(ctx.Flags().String("key"), a.opts.KeyFile),
+		Protocol:         coalesce.String(ctx.Flags().String("proto"), a.opts.Protocol, "https"),
+	}
+}
+
+func (a *App) listen(ctx context.Context, s *stdapi.Server, opts listenOptions) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := a.start(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	err := a.serveUntilDone(ctx, s, opts)
+
+	sctx, scancel := context.WithTimeout(context.Background(), opts.Drain)
+	defer scancel()
+
+	return errors.Join(err, a.shutdown(sctx))
+}
+
+func (a *App) serveUntilDone(ctx context.Context, s *stdapi.Server, opts listenOptions) error {
+	s.Logger.At("listen").Logf("hostname=%q proto=%q addr=%q", s.Hostname, opts.Protocol, opts.Addr)
+
+	l, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	switch opts.Protocol {
+	case "http":
+	case "h2", "https", "tls":
+		config, err := a.tlsConfig(ctx, s.Hostname, opts)
+		if err != nil {
+			l.Close()
+			return errors.Wrap(err)
+		}
+
+		l = tls.NewListener(l, config)
+	default:
+		l.Close()
+		return errors.Errorf("unknown protocol: %s", opts.Protocol)
+	}
+
+	d := newDrainer()
+
+	h := http.Handler(s)
+
+	if s.Wrapper != nil {
+		h = s.Wrapper(h)
+	}
+
+	server := &http.Server{Handler: d.wrap(h)}
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- server.Serve(l)
+	}()
+
+	if err := a.ready(ctx); err != nil {
+		server.Close()
+		return errors.Wrap(err)
+	}
+
+	select {
+	case err := <-errc:
+		return errors.Wrap(err)
+	case <-ctx.Done():
+	}
+
+	a.logger.At("shutdown").Logf("timeout=%s websockets=%d", opts.Drain, d.count())
+
+	sctx, scancel := context.WithTimeout(context.Background(), opts.Drain)
+	defer scancel()
+
+	shutdown := make(chan error, 1)
+
+	go func() {
+		shutdown <- server.Shutdown(sctx)
+	}()
+
+	d.close()
+
+	if err := <-shutdown; err != nil {
+		return errors.Errorf("shutdown incomplete after %s: %s", opts.Drain, err)
+	}
+
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err)
+	}
+
+	a.logger.At("shutdown").Logf("state=complete")
+
+	return nil
+}
@@ -0,0 +1,302 @@
+package stdapp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/errors"
+)
+
+const (
+	loaderMaxBatch = 100
+	loaderWait     = 2 * time.Millisecond
+)
+
+var contextLoaders = contextKey("loaders")
+
+// LoaderFunc loads the values for a batch of keys, keys without a value are
+// left out of the map
+type LoaderFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Loader collects the keys loaded by resolvers running at the same time into
+// a single call to Func and caches the results for the rest of the request,
+// unless the call fails. Loaders are declared once and keep their state in
+// the request context that stdapp gives every GraphQL operation, for
+// subscriptions the cache is cleared after each event. Outside of an
+// operation every Load calls Func.
+type Loader[K comparable, V any] struct {
+	Func     LoaderFunc[K, V]
+	MaxBatch int
+	Wait     time.Duration
+}
+
+func NewLoader[K comparable, V any](fn LoaderFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{Func: fn}
+}
+
+// SelectLoader loads models whose column matches the keys with a single
+// query against the operation's domain
+func SelectLoader[K comparable, M any](column string, key func(M) K) LoaderFunc[K, M] {
+	return func(ctx context.Context, keys []K) (map[K]M, error) {
+		rows := []M{}
+
+		if err := loaderSelect(ctx, column, keys, &rows); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		values := map[K]M{}
+
+		for _, row := range rows {
+			values[key(row)] = row
+		}
+
+		return values, nil
+	}
+}
+
+// SelectGroupLoader loads the models belonging to each key, such as the
+// children of a batch of parents, with a single query
+func SelectGroupLoader[K comparable, M any](column string, key func(M) K) LoaderFunc[K, []M] {
+	return func(ctx context.Context, keys []K) (map[K][]M, error) {
+		rows := []M{}
+
+		if err := loaderSelect(ctx, column, keys, &rows); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		values := map[K][]M{}
+
+		for _, row := range rows {
+			values[key(row)] = append(values[key(row)], row)
+		}
+
+		return values, nil
+	}
+}
+
+func loaderSelect(ctx context.Context, column string, keys, rows any) error {
+	a, ok := ctx.Value(contextApp).(*App)
+	if !ok {
+		return errors.Errorf("select loaders must be used by stdapp resolvers")
+	}
+
+	db, err := a.DB(Domain(ctx))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := db.NewSelect().Model(rows).Where("? IN (?)", bun.Ident(column), bun.In(keys)).Scan(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Load returns the value for key, or the zero value if Func didn't return one
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	var zero V
+
+	ls, ok := ctx.Value(contextLoaders).(*loaders)
+	if !ok {
+		values, err := l.call(ctx, []K{key})
+		if err != nil {
+			return zero, err
+		}
+		return values[key], nil
+	}
+
+	r := ls.state(l, func() any { return &loaderState[K, V]{cache: map[K]*loaderResult[V]{}} }).(*loaderState[K, V]).load(ls.context(ctx), l, key)
+
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, errors.Wrap(ctx.Err())
+	}
+}
+
+// LoadMany returns the values for keys in the same order
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, error) {
+	values := make([]V, len(keys))
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+
+	for i := range keys {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = l.Load(ctx, keys[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func (l *Loader[K, V]) call(ctx context.Context, keys []K) (values map[K]V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+
+	values, err = l.Func(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return values, nil
+}
+
+// loaders holds the state of each Loader used during an operation
+type loaders struct {
+	ctx    context.Context
+	lock   sync.Mutex
+	states map[any]any
+}
+
+func newLoaders() *loaders {
+	return &loaders{states: map[any]any{}}
+}
+
+// bind sets the operation context that batches run with, so that a batch
+// doesn't fail for every waiter when the resolver that started it is cancelled
+func (ls *loaders) bind(ctx context.Context) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	ls.ctx = ctx
+}
+
+func (ls *loaders) context(ctx context.Context) context.Context {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if ls.ctx == nil {
+		return ctx
+	}
+
+	return ls.ctx
+}
+
+func (ls *loaders) reset() {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	ls.states = map[any]any{}
+}
+
+func (ls *loaders) state(loader any, init func() any) any {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	s, ok := ls.states[loader]
+	if !ok {
+		s = init()
+		ls.states[loader] = s
+	}
+
+	return s
+}
+
+type loaderState[K comparable, V any] struct {
+	batch *loaderBatch[K, V]
+	cache map[K]*loaderResult[V]
+	lock  sync.Mutex
+}
+
+type loaderBatch[K comparable, V any] struct {
+	keys    []K
+	results []*loaderResult[V]
+}
+
+type loaderResult[V any] struct {
+	done  chan struct{}
+	err   error
+	value V
+}
+
+// load adds key to the pending batch, which runs once it is full or Wait
+// passes without it filling up
+func (s *loaderState[K, V]) load(ctx context.Context, l *Loader[K, V], key K) *loaderResult[V] {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r, ok := s.cache[key]; ok {
+		return r
+	}
+
+	r := &loaderResult[V]{done: make(chan struct{})}
+
+	s.cache[key] = r
+
+	if s.batch == nil {
+		b := &loaderBatch[K, V]{}
+		s.batch = b
+
+		time.AfterFunc(coalesce.Any(l.Wait, loaderWait), func() {
+			s.dispatch(ctx, l, b)
+		})
+	}
+
+	s.batch.keys = append(s.batch.keys, key)
+	s.batch.results = append(s.batch.results, r)
+
+	if len(s.batch.keys) >= coalesce.Any(l.MaxBatch, loaderMaxBatch) {
+		b := s.batch
+		s.batch = nil
+		go s.run(ctx, l, b)
+	}
+
+	return r
+}
+
+func (s *loaderState[K, V]) dispatch(ctx context.Context, l *Loader[K, V], b *loaderBatch[K, V]) {
+	s.lock.Lock()
+
+	if s.batch != b {
+		s.lock.Unlock()
+		return
+	}
+
+	s.batch = nil
+
+	s.lock.Unlock()
+
+	s.run(ctx, l, b)
+}
+
+// run calls Func for the batch, failed keys are dropped from the cache so
+// that a later Load tries them again
+func (s *loaderState[K, V]) run(ctx context.Context, l *Loader[K, V], b *loaderBatch[K, V]) {
+	values, err := l.call(ctx, b.keys)
+
+	if err != nil {
+		s.lock.Lock()
+
+		for i, key := range b.keys {
+			if s.cache[key] == b.results[i] {
+				delete(s.cache, key)
+			}
+		}
+
+		s.lock.Unlock()
+	}
+
+	for i, key := range b.keys {
+		b.results[i].value, b.results[i].err = values[key], err
+		close(b.results[i].done)
+	}
+}
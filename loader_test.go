@@ -0,0 +1,102 @@
+package stdapp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.ddollar.dev/errors"
+)
+
+func TestLoaderBatchContext(t *testing.T) {
+	l := &Loader[int, int]{
+		Func: func(ctx context.Context, keys []int) (map[int]int, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			values := map[int]int{}
+
+			for _, key := range keys {
+				values[key] = key * 10
+			}
+
+			return values, nil
+		},
+		Wait: 20 * time.Millisecond,
+	}
+
+	ls := newLoaders()
+
+	octx, cancel := context.WithCancel(context.WithValue(context.Background(), contextLoaders, ls))
+	defer cancel()
+
+	ls.bind(octx)
+
+	// the first resolver starts the batch then gives up on it
+	fctx, fcancel := context.WithCancel(octx)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if _, err := l.Load(fctx, 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the cancelled resolver to fail, got %v", err)
+		}
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	fcancel()
+
+	for _, key := range []int{1, 2} {
+		value, err := l.Load(octx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value != key*10 {
+			t.Fatalf("expected %d, got %d", key*10, value)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestLoaderFailureNotCached(t *testing.T) {
+	var calls atomic.Int32
+
+	l := &Loader[int, int]{
+		Func: func(ctx context.Context, keys []int) (map[int]int, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.Errorf("unavailable")
+			}
+
+			return map[int]int{keys[0]: 1}, nil
+		},
+		Wait: time.Millisecond,
+	}
+
+	ctx := context.WithValue(context.Background(), contextLoaders, newLoaders())
+
+	if _, err := l.Load(ctx, 1); err == nil {
+		t.Fatal("expected the first load to fail")
+	}
+
+	value, err := l.Load(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != 1 {
+		t.Fatalf("expected 1, got %d", value)
+	}
+
+	if _, err := l.Load(ctx, 1); err != nil || calls.Load() != 2 {
+		t.Fatalf("expected the successful load to be cached, got %d calls", calls.Load())
+	}
+}
@@ -0,0 +1,393 @@
+package stdapp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/migrate"
+)
+
+// _migrations belongs to go.ddollar.dev/migrate and only holds versions, so
+// stdapp records when it applied each one alongside
+const migrationTimesTable = `
+CREATE TABLE IF NOT EXISTS _migration_times (
+	version text PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+);
+`
+
+// migrationState is a version of a domain's migrations that is applied,
+// pending, or missing when it was applied but its file is no longer there
+type migrationState struct {
+	Applied time.Time
+	State   string
+	Version string
+}
+
+func (a *App) Migrate(ctx context.Context, opts migrate.Options) error {
+	dsn, err := a.databaseURL()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if root := a.Schema(""); root != "" && !opts.DryRun {
+		db, err := a.DB("")
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quoteIdentifier(root))); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if err := a.migrateDomain(ctx, u, "", opts); err != nil {
+		return errors.Wrap(err)
+	}
+
+	// the registry is read after the root is migrated as that creates it
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, domain := range a.domains() {
+		if err := a.migrateDomain(ctx, u, domain, opts); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (a *App) migrateDomain(ctx context.Context, u *url.URL, domain string, opts migrate.Options) error {
+	mfs, err := a.migrations(domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	applied, err := a.appliedMigrations(ctx, domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if mfs != nil {
+		du := *u
+
+		q := du.Query()
+		q.Set("search_path", a.Schema(domain))
+		du.RawQuery = q.Encode()
+
+		if err := migrate.Run(ctx, du.String(), mfs, opts); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if !opts.DryRun {
+		if err := a.prepare(ctx, domain); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if err := a.recordMigrations(ctx, domain, applied); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// recordMigrations notes the time of the versions applied since before,
+// those applied before the times were kept don't get one
+func (a *App) recordMigrations(ctx context.Context, domain string, before map[string]bool) error {
+	applied, err := a.appliedMigrations(ctx, domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	db, err := a.DB(domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	for v := range applied {
+		if before[v] {
+			continue
+		}
+
+		if _, err := db.ExecContext(ctx, "INSERT INTO _migration_times (version) VALUES (?) ON CONFLICT DO NOTHING", v); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// prepare creates the tables stdapp itself keeps in each domain schema, or
+// in the root schema, leaving out those of features the app doesn't use
+func (a *App) prepare(ctx context.Context, domain string) error {
+	db, err := a.DB(domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	tables := []string{migrationTimesTable}
+
+	if domain != "" && len(a.opts.Jobs) > 0 {
+		tables = append(tables, jobsTable)
+	}
+
+	if domain == "" {
+		if len(a.opts.Schedules) > 0 {
+			tables = append(tables, schedulesTable)
+		}
+
+		if a.opts.DynamicDomains {
+			tables = append(tables, domainsTable)
+		}
+
+		if a.opts.PersistedQueries == "postgres" {
+			tables = append(tables, persistedQueriesTable)
+		}
+	}
+
+	for _, table := range tables {
+		if _, err := db.ExecContext(ctx, table); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// migrations for a domain are those in its DomainConfig or otherwise in
+// db/migrate/<domain>, with DynamicDomains a domain without a directory of
+// its own uses db/migrate/_domain. Without any migrations this returns nil.
+func (a *App) migrations(domain string) (fs.FS, error) {
+	if domain != "" {
+		if mfs := a.domainConfig(domain).Migrations; mfs != nil {
+			return mfs, nil
+		}
+	}
+
+	if a.opts.Migrations == nil {
+		return nil, nil
+	}
+
+	dir := filepath.Join("db", "migrate", domain)
+
+	if a.opts.DynamicDomains && domain != "" {
+		if _, err := fs.Stat(a.opts.Migrations, dir); errors.Is(err, fs.ErrNotExist) {
+			dir = filepath.Join("db", "migrate", domainMigrations)
+		}
+	}
+
+	mfs, err := fs.Sub(a.opts.Migrations, dir)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return mfs, nil
+}
+
+// migrationVersions lists versions the same way go.ddollar.dev/migrate does
+func (a *App) migrationVersions(domain string) ([]string, error) {
+	mfs, err := a.migrations(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if mfs == nil {
+		return []string{}, nil
+	}
+
+	files, err := fs.ReadDir(mfs, ".")
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	vs := map[string]bool{}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		parts := strings.SplitN(file.Name(), ".", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid migration: %s", file.Name())
+		}
+
+		vs[parts[0]] = true
+	}
+
+	versions := []string{}
+
+	for v := range vs {
+		versions = append(versions, v)
+	}
+
+	sort.Strings(versions)
+
+	return versions, nil
+}
+
+func (a *App) appliedMigrations(ctx context.Context, domain string) (map[string]bool, error) {
+	db, err := a.DB(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	applied := map[string]bool{}
+
+	var exists bool
+
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM _migrations")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v string
+
+		if err := rows.Scan(&v); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		applied[v] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return applied, nil
+}
+
+func (a *App) pendingMigrations(ctx context.Context, domain string) ([]string, error) {
+	versions, err := a.migrationVersions(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	applied, err := a.appliedMigrations(ctx, domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	pending := []string{}
+
+	for _, v := range versions {
+		if !applied[v] {
+			pending = append(pending, v)
+		}
+	}
+
+	return pending, nil
+}
+
+// migrationStatus compares the migrations on disk for a domain with those
+// applied to its schema, in order of version
+func (a *App) migrationStatus(ctx context.Context, domain string) ([]migrationState, error) {
+	versions, err := a.migrationVersions(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	applied, err := a.appliedMigrations(ctx, domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	times, err := a.migrationTimes(ctx, domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	states := []migrationState{}
+
+	for _, v := range versions {
+		s := migrationState{Applied: times[v], State: "pending", Version: v}
+
+		if applied[v] {
+			s.State = "applied"
+		}
+
+		states = append(states, s)
+	}
+
+	for v := range applied {
+		if !slices.Contains(versions, v) {
+			states = append(states, migrationState{Applied: times[v], State: "missing", Version: v})
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+
+	return states, nil
+}
+
+func (a *App) migrationTimes(ctx context.Context, domain string) (map[string]time.Time, error) {
+	db, err := a.DB(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	times := map[string]time.Time{}
+
+	var exists bool
+
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('_migration_times') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if !exists {
+		return times, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM _migration_times")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v string
+		var t time.Time
+
+		if err := rows.Scan(&v, &t); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		times[v] = t
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return times, nil
+}
@@ -0,0 +1,181 @@
+package stdapp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/uptrace/bun/driver/pgdriver"
+	"go.ddollar.dev/errors"
+)
+
+const notifyBuffer = 16
+
+var contextApp = contextKey("app")
+
+// Notifier shares a single LISTEN connection between every subscriber in a
+// domain, the connection is pinged and reconnected by pgdriver which listens
+// again on every channel in use
+type Notifier struct {
+	app      *App
+	domain   string
+	listener *pgdriver.Listener
+	lock     sync.Mutex
+	schema   string
+	subs     map[string]map[chan string]struct{}
+}
+
+// TableChanged can be embedded in a Subscription resolver to serve
+//
+//	table_changed(name: String!): String!
+//
+// for tables with a table_changed('name') trigger
+type TableChanged struct{}
+
+func (TableChanged) TableChanged(ctx context.Context, args struct{ Name string }) (<-chan string, error) {
+	a, ok := ctx.Value(contextApp).(*App)
+	if !ok {
+		return nil, errors.Errorf("table_changed must be served by stdapp")
+	}
+
+	n, err := a.Notifier(ctx, Domain(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return n.Subscribe(ctx, args.Name)
+}
+
+// Notifier returns the notification hub for a domain, starting it on first use
+func (a *App) Notifier(ctx context.Context, domain string) (*Notifier, error) {
+	a.lock.Lock()
+	n, ok := a.notifiers[domain]
+	a.lock.Unlock()
+
+	if ok {
+		return n, nil
+	}
+
+	db, err := a.DB(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	schema := a.Schema(domain)
+
+	if schema == "" {
+		if err := db.QueryRowContext(ctx, "SELECT current_schema()").Scan(&schema); err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if n, ok := a.notifiers[domain]; ok {
+		return n, nil
+	}
+
+	n = &Notifier{
+		app:      a,
+		domain:   domain,
+		listener: pgdriver.NewListener(db),
+		schema:   schema,
+		subs:     map[string]map[chan string]struct{}{},
+	}
+
+	go n.dispatch(n.listener.Channel())
+
+	a.notifiers[domain] = n
+
+	return n, nil
+}
+
+// Subscribe receives the payload of every notification sent for name in this
+// domain until ctx is done, notifications are dropped while the subscriber
+// is behind rather than holding up the others
+func (n *Notifier) Subscribe(ctx context.Context, name string) (<-chan string, error) {
+	channel := n.channel(name)
+	ch := make(chan string, notifyBuffer)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	// a failed LISTEN is retried by the listener when it reconnects
+	if len(n.subs[channel]) == 0 {
+		n.subs[channel] = map[chan string]struct{}{}
+
+		if err := n.listener.Listen(ctx, channel); err != nil {
+			n.app.logger.At("notify").Logf("domain=%s channel=%s error=%q", n.domain, channel, err)
+		}
+	}
+
+	n.subs[channel][ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		n.unsubscribe(channel, ch)
+	}()
+
+	return ch, nil
+}
+
+// channel qualifies name with the schema so that tables with the same name in
+// different domains don't share notifications
+func (n *Notifier) channel(name string) string {
+	return fmt.Sprintf("%s.%s", n.schema, name)
+}
+
+func (n *Notifier) close() error {
+	return errors.Wrap(n.listener.Close())
+}
+
+func (n *Notifier) dispatch(notifications <-chan pgdriver.Notification) {
+	for msg := range notifications {
+		n.lock.Lock()
+
+		for ch := range n.subs[msg.Channel] {
+			select {
+			case ch <- msg.Payload:
+			default:
+			}
+		}
+
+		n.lock.Unlock()
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for channel, subs := range n.subs {
+		for ch := range subs {
+			close(ch)
+		}
+
+		delete(n.subs, channel)
+	}
+}
+
+// unsubscribe holds the lock across UNLISTEN so that it can't overtake the
+// LISTEN of a new subscriber to the same channel
+func (n *Notifier) unsubscribe(channel string, ch chan string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.subs[channel][ch]; !ok {
+		return
+	}
+
+	delete(n.subs[channel], ch)
+	close(ch)
+
+	if len(n.subs[channel]) > 0 {
+		return
+	}
+
+	delete(n.subs, channel)
+
+	if err := n.listener.Unlisten(context.Background(), channel); err != nil {
+		n.app.logger.At("notify").Logf("domain=%s channel=%s error=%q", n.domain, channel, err)
+	}
+}
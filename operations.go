@@ -0,0 +1,211 @@
+package stdapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	gqlintrospection "github.com/graph-gophers/graphql-go/introspection"
+	"github.com/graph-gophers/graphql-go/trace/tracer"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/logger"
+)
+
+const slowFields = 10
+
+var contextTimings = contextKey("timings")
+
+// redactVariables are used when Options.RedactVariables is not set
+var redactVariables = []string{"apikey", "authorization", "password", "secret", "token"}
+
+// operationLog writes a line for each GraphQL operation once it finishes,
+// over HTTP or a websocket, and for operations slower than SlowOperation a
+// line for each of the fields that took the longest to resolve
+type operationLog struct {
+	errors    int
+	events    int
+	graph     *graph
+	kind      string
+	log       *logger.Logger
+	name      string
+	start     time.Time
+	timings   *fieldTimings
+	variables map[string]any
+}
+
+func (g *graph) startOperation(ctx context.Context, name string, variables map[string]any) (context.Context, *operationLog) {
+	o := &operationLog{
+		graph:     g,
+		log:       g.app.logger.At("graphql").Append("domain=%s request=%s", g.domain, RequestID(ctx)).Start(),
+		name:      name,
+		start:     time.Now(),
+		variables: variables,
+	}
+
+	if g.app.opts.SlowOperation > 0 {
+		o.timings = &fieldTimings{fields: map[string]*fieldTiming{}}
+		ctx = context.WithValue(ctx, contextTimings, o.timings)
+	}
+
+	return ctx, o
+}
+
+// operation records what was parsed from the document, operations that
+// didn't parse are logged with the name they were sent with
+func (o *operationLog) operation(op *ast.OperationDefinition) {
+	if op == nil {
+		return
+	}
+
+	o.kind = operationType(op)
+	o.name = coalesce.String(o.name, op.Name.Name)
+}
+
+func (o *operationLog) response(res *graphql.Response) {
+	o.errors += len(res.Errors)
+	o.events++
+}
+
+func (o *operationLog) finish() {
+	variables, err := json.Marshal(o.graph.redact(o.variables))
+	if err != nil {
+		variables = []byte("{}")
+	}
+
+	log := o.log.Append("operation=%q type=%s", o.name, coalesce.String(o.kind, "unknown"))
+
+	if o.kind == "subscription" {
+		log.Logf("events=%d errors=%d variables=%q", o.events, o.errors, variables)
+		return
+	}
+
+	log.Logf("errors=%d variables=%q", o.errors, variables)
+
+	if o.timings == nil || time.Since(o.start) < o.graph.app.opts.SlowOperation {
+		return
+	}
+
+	for _, f := range o.timings.slowest(slowFields) {
+		log.Logf("slow=true field=%s calls=%d total=%0.3f max=%0.3f", f.name, f.calls, milliseconds(f.total), milliseconds(f.max))
+	}
+}
+
+// redact replaces the values of variables and input fields whose names
+// contain one of RedactVariables, ignoring case and underscores
+func (g *graph) redact(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		rules := coalesce.Any(g.app.opts.RedactVariables, redactVariables)
+
+		values := map[string]any{}
+
+		for k, v := range t {
+			values[k] = g.redact(v)
+
+			name := strings.ToLower(strings.ReplaceAll(k, "_", ""))
+
+			for _, rule := range rules {
+				if strings.Contains(name, strings.ToLower(strings.ReplaceAll(rule, "_", ""))) {
+					values[k] = "[REDACTED]"
+					break
+				}
+			}
+		}
+
+		return values
+	case []any:
+		values := make([]any, len(t))
+
+		for i := range t {
+			values[i] = g.redact(t[i])
+		}
+
+		return values
+	default:
+		return v
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1000000
+}
+
+type fieldTimings struct {
+	fields map[string]*fieldTiming
+	lock   sync.Mutex
+}
+
+type fieldTiming struct {
+	calls int
+	max   time.Duration
+	name  string
+	total time.Duration
+}
+
+func (ft *fieldTimings) add(name string, d time.Duration) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	f, ok := ft.fields[name]
+	if !ok {
+		f = &fieldTiming{name: name}
+		ft.fields[name] = f
+	}
+
+	f.calls++
+	f.total += d
+
+	if d > f.max {
+		f.max = d
+	}
+}
+
+func (ft *fieldTimings) slowest(n int) []*fieldTiming {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	fields := []*fieldTiming{}
+
+	for _, f := range ft.fields {
+		fields = append(fields, f)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].total > fields[j].total
+	})
+
+	if len(fields) > n {
+		fields = fields[:n]
+	}
+
+	return fields
+}
+
+// graphTracer times the resolvers of operations that carry fieldTimings,
+// fields that are plain struct fields or methods without a context aren't
+// worth timing
+type graphTracer struct{}
+
+func (graphTracer) TraceQuery(ctx context.Context, queryString string, operationName string, variables map[string]any, varTypes map[string]*gqlintrospection.Type) (context.Context, tracer.QueryFinishFunc) {
+	return ctx, func([]*gqlerrors.QueryError) {}
+}
+
+func (graphTracer) TraceField(ctx context.Context, label, typeName, fieldName string, trivial bool, args map[string]any) (context.Context, tracer.FieldFinishFunc) {
+	ft, ok := ctx.Value(contextTimings).(*fieldTimings)
+	if !ok || trivial {
+		return ctx, func(*gqlerrors.QueryError) {}
+	}
+
+	start := time.Now()
+
+	return ctx, func(*gqlerrors.QueryError) {
+		ft.add(fmt.Sprintf("%s.%s", typeName, fieldName), time.Since(start))
+	}
+}
@@ -0,0 +1,355 @@
+package stdapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+	"go.ddollar.dev/errors"
+)
+
+const (
+	pageSize    = 20
+	pageSizeMax = 100
+)
+
+// PageArgs are the arguments of a relay connection field, embed them in the
+// args struct of a resolver that takes other arguments as well
+type PageArgs struct {
+	After  *string
+	Before *string
+	First  *int32
+	Last   *int32
+}
+
+// Connection is a page of nodes in the shape of a relay connection, its
+// schema types are declared with ConnectionSchema
+type Connection[T any] struct {
+	edges    []*Edge[T]
+	pageInfo *PageInfo
+}
+
+type Edge[T any] struct {
+	cursor string
+	node   T
+}
+
+type PageInfo struct {
+	endCursor       *string
+	hasNextPage     bool
+	hasPreviousPage bool
+	startCursor     *string
+}
+
+type pageKey struct {
+	column string
+	desc   bool
+}
+
+// ConnectionSchema returns the PageInfo type and a <Type>Connection and
+// <Type>Edge type for each of types, to be added to a resolver's schema
+func ConnectionSchema(types ...string) string {
+	var s strings.Builder
+
+	s.WriteString("type PageInfo {\n\tendCursor: String\n\thasNextPage: Boolean!\n\thasPreviousPage: Boolean!\n\tstartCursor: String\n}\n")
+
+	for _, t := range types {
+		fmt.Fprintf(&s, "\ntype %sConnection {\n\tedges: [%sEdge!]!\n\tnodes: [%s!]!\n\tpageInfo: PageInfo!\n}\n", t, t, t)
+		fmt.Fprintf(&s, "\ntype %sEdge {\n\tcursor: String!\n\tnode: %s!\n}\n", t, t)
+	}
+
+	return s.String()
+}
+
+// Paginate runs q for one page of rows using keyset pagination on order, a
+// list of columns in the form accepted by bun's Order such as
+// "created_at DESC, id". The columns must identify a row uniquely so that
+// pages are stable as rows are added and removed, and q must not be ordered
+// or limited itself. The columns must also be NOT NULL, a row with a null in
+// one of them is an error.
+func Paginate[M any](ctx context.Context, q *bun.SelectQuery, order string, args PageArgs) (*Connection[M], error) {
+	keys, err := pageKeys(order)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	size, backward, err := args.size()
+	if err != nil {
+		return nil, err
+	}
+
+	table := q.DB().Table(reflect.TypeOf((*M)(nil)).Elem())
+
+	fields := make([]*schema.Field, len(keys))
+
+	for i, k := range keys {
+		f, err := table.Field(k.column[strings.LastIndex(k.column, ".")+1:])
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		fields[i] = f
+	}
+
+	if args.After != nil {
+		if err := pageWhere(q, keys, *args.After, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Before != nil {
+		if err := pageWhere(q, keys, *args.Before, true); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, k := range keys {
+		if k.desc != backward {
+			q = q.OrderExpr("? DESC", bun.Ident(k.column))
+		} else {
+			q = q.OrderExpr("? ASC", bun.Ident(k.column))
+		}
+	}
+
+	rows := []M{}
+
+	if err := q.Model(&rows).Limit(size + 1).Scan(ctx); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	more := len(rows) > size
+
+	if more {
+		rows = rows[:size]
+	}
+
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	c := &Connection[M]{
+		edges: make([]*Edge[M], len(rows)),
+		pageInfo: &PageInfo{
+			hasNextPage:     args.Before != nil,
+			hasPreviousPage: args.After != nil,
+		},
+	}
+
+	if backward {
+		c.pageInfo.hasPreviousPage = more
+	} else {
+		c.pageInfo.hasNextPage = more
+	}
+
+	for i, row := range rows {
+		cursor, err := pageCursor(fields, row)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		c.edges[i] = &Edge[M]{cursor: cursor, node: row}
+	}
+
+	if len(c.edges) > 0 {
+		c.pageInfo.startCursor = &c.edges[0].cursor
+		c.pageInfo.endCursor = &c.edges[len(c.edges)-1].cursor
+	}
+
+	return c, nil
+}
+
+// MapConnection converts the nodes of a connection, such as to wrap models
+// in their resolvers
+func MapConnection[M, T any](c *Connection[M], fn func(M) T) *Connection[T] {
+	mc := &Connection[T]{edges: make([]*Edge[T], len(c.edges)), pageInfo: c.pageInfo}
+
+	for i, e := range c.edges {
+		mc.edges[i] = &Edge[T]{cursor: e.cursor, node: fn(e.node)}
+	}
+
+	return mc
+}
+
+func (c *Connection[T]) Edges() []*Edge[T] {
+	return c.edges
+}
+
+func (c *Connection[T]) Nodes() []T {
+	nodes := make([]T, len(c.edges))
+
+	for i, e := range c.edges {
+		nodes[i] = e.node
+	}
+
+	return nodes
+}
+
+func (c *Connection[T]) PageInfo() *PageInfo {
+	return c.pageInfo
+}
+
+func (e *Edge[T]) Cursor() string {
+	return e.cursor
+}
+
+func (e *Edge[T]) Node() T {
+	return e.node
+}
+
+func (pi *PageInfo) EndCursor() *string {
+	return pi.endCursor
+}
+
+func (pi *PageInfo) HasNextPage() bool {
+	return pi.hasNextPage
+}
+
+func (pi *PageInfo) HasPreviousPage() bool {
+	return pi.hasPreviousPage
+}
+
+func (pi *PageInfo) StartCursor() *string {
+	return pi.startCursor
+}
+
+// size is the number of rows requested and whether they are counted back
+// from the end with last
+func (args PageArgs) size() (int, bool, error) {
+	switch {
+	case args.First != nil && args.Last != nil:
+		return 0, false, UserErrorf("BAD_USER_INPUT", "first and last can not be used together")
+	case args.First != nil:
+		if *args.First < 0 || *args.First > pageSizeMax {
+			return 0, false, UserErrorf("BAD_USER_INPUT", "first must be between 0 and %d", pageSizeMax)
+		}
+		return int(*args.First), false, nil
+	case args.Last != nil:
+		if *args.Last < 0 || *args.Last > pageSizeMax {
+			return 0, false, UserErrorf("BAD_USER_INPUT", "last must be between 0 and %d", pageSizeMax)
+		}
+		return int(*args.Last), true, nil
+	default:
+		return pageSize, false, nil
+	}
+}
+
+func pageKeys(order string) ([]pageKey, error) {
+	keys := []pageKey{}
+
+	for _, part := range strings.Split(order, ",") {
+		words := strings.Fields(part)
+
+		switch {
+		case len(words) == 1:
+			keys = append(keys, pageKey{column: words[0]})
+		case len(words) == 2 && strings.EqualFold(words[1], "asc"):
+			keys = append(keys, pageKey{column: words[0]})
+		case len(words) == 2 && strings.EqualFold(words[1], "desc"):
+			keys = append(keys, pageKey{column: words[0], desc: true})
+		default:
+			return nil, errors.Errorf("invalid page order: %s", order)
+		}
+	}
+
+	return keys, nil
+}
+
+// pageWhere limits q to the rows after the cursor in order, or before it.
+// With more than one key this is (a > x) OR (a = x AND b > y) and so on, as
+// a row comparison can't mix directions.
+func pageWhere(q *bun.SelectQuery, keys []pageKey, cursor string, before bool) error {
+	values, err := pageValues(cursor, len(keys))
+	if err != nil {
+		return err
+	}
+
+	q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+		for i := range keys {
+			exprs := []string{}
+			args := []any{}
+
+			for j := 0; j < i; j++ {
+				exprs = append(exprs, "? = ?")
+				args = append(args, bun.Ident(keys[j].column), values[j])
+			}
+
+			if keys[i].desc != before {
+				exprs = append(exprs, "? < ?")
+			} else {
+				exprs = append(exprs, "? > ?")
+			}
+
+			args = append(args, bun.Ident(keys[i].column), values[i])
+
+			q = q.WhereOr(strings.Join(exprs, " AND "), args...)
+		}
+
+		return q
+	})
+
+	return nil
+}
+
+// pageCursor encodes the values of the order columns for row. Values are
+// compared as literals when decoded, which postgres casts to the column's
+// type. A null can't be compared that way, so it's an error here rather than
+// a cursor the next request would reject.
+func pageCursor(fields []*schema.Field, row any) (string, error) {
+	strct := reflect.Indirect(reflect.ValueOf(row))
+
+	values := make([]json.RawMessage, len(fields))
+
+	for i, f := range fields {
+		data, err := json.Marshal(f.Value(strct).Interface())
+		if err != nil {
+			return "", errors.Wrap(err)
+		}
+
+		if string(data) == "null" {
+			return "", errors.Errorf("page order column is null: %s", f.Name)
+		}
+
+		values[i] = data
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func pageValues(cursor string, count int) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, UserErrorf("BAD_USER_INPUT", "invalid cursor")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	values := []any{}
+
+	if err := dec.Decode(&values); err != nil || len(values) != count {
+		return nil, UserErrorf("BAD_USER_INPUT", "invalid cursor")
+	}
+
+	for _, v := range values {
+		switch v.(type) {
+		case bool, json.Number, string:
+		default:
+			return nil, UserErrorf("BAD_USER_INPUT", "invalid cursor")
+		}
+	}
+
+	return values, nil
+}
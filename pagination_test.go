@@ -0,0 +1,222 @@
+package stdapp
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/schema"
+	"go.ddollar.dev/errors"
+)
+
+type pageModel struct {
+	ID      int64     `bun:"id,pk"`
+	Name    string    `bun:"name"`
+	Created time.Time `bun:"created_at"`
+	Active  bool      `bun:"active"`
+	Parent  *int64    `bun:"parent_id"`
+}
+
+// pageDB never connects, it only builds queries
+func pageDB(t *testing.T) *bun.DB {
+	t.Helper()
+
+	db := bun.NewDB(sql.OpenDB(pgdriver.NewConnector()), pgdialect.New())
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestPageCursor(t *testing.T) {
+	created := time.Date(2024, 2, 29, 12, 30, 0, 123456000, time.UTC)
+	parent := int64(3)
+
+	tests := []struct {
+		name    string
+		columns []string
+		row     pageModel
+		values  []any
+		err     string
+	}{
+		{name: "integer", columns: []string{"id"}, row: pageModel{ID: 42}, values: []any{json.Number("42")}},
+		{name: "large integer", columns: []string{"id"}, row: pageModel{ID: 9007199254740993}, values: []any{json.Number("9007199254740993")}},
+		{name: "time and id", columns: []string{"created_at", "id"}, row: pageModel{ID: 7, Created: created}, values: []any{"2024-02-29T12:30:00.123456Z", json.Number("7")}},
+		{name: "bool", columns: []string{"active"}, row: pageModel{Active: true}, values: []any{true}},
+		{name: "empty string", columns: []string{"name"}, row: pageModel{}, values: []any{""}},
+		{name: "unicode", columns: []string{"name"}, row: pageModel{Name: "Zoë 日本"}, values: []any{"Zoë 日本"}},
+		{name: "byte order mark", columns: []string{"name"}, row: pageModel{Name: "\uFEFFname"}, values: []any{"\uFEFFname"}},
+		{name: "quotes", columns: []string{"name"}, row: pageModel{Name: `a "b" 'c' \d`}, values: []any{`a "b" 'c' \d`}},
+		{name: "nullable", columns: []string{"parent_id", "id"}, row: pageModel{ID: 7, Parent: &parent}, values: []any{json.Number("3"), json.Number("7")}},
+		{name: "null", columns: []string{"parent_id", "id"}, row: pageModel{ID: 7}, err: "page order column is null: parent_id"},
+	}
+
+	table := pageDB(t).Table(reflect.TypeOf(pageModel{}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := make([]*schema.Field, len(tt.columns))
+
+			for i, column := range tt.columns {
+				f, err := table.Field(column)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				fields[i] = f
+			}
+
+			cursor, err := pageCursor(fields, &tt.row)
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := base64.RawURLEncoding.DecodeString(cursor); err != nil {
+				t.Fatalf("expected a url safe cursor, got %q", cursor)
+			}
+
+			values, err := pageValues(cursor, len(tt.columns))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Fatalf("expected %#v, got %#v", tt.values, values)
+			}
+		})
+	}
+}
+
+func TestPageValues(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		count  int
+		values []any
+	}{
+		{name: "valid", cursor: encode(`[1,"a",false]`), count: 3, values: []any{json.Number("1"), "a", false}},
+		{name: "padded", cursor: base64.URLEncoding.EncodeToString([]byte(`[12]`)), count: 1},
+		{name: "standard alphabet", cursor: base64.StdEncoding.EncodeToString([]byte(`["ÿÿ?"]`)), count: 1},
+		{name: "not base64", cursor: "not a cursor!", count: 1},
+		{name: "empty", cursor: "", count: 1},
+		{name: "not json", cursor: encode(`[1`), count: 1},
+		{name: "object", cursor: encode(`{"id":1}`), count: 1},
+		{name: "too few", cursor: encode(`[1]`), count: 2},
+		{name: "too many", cursor: encode(`[1,2]`), count: 1},
+		{name: "null", cursor: encode(`[null]`), count: 1},
+		{name: "nested", cursor: encode(`[[1]]`), count: 1},
+		{name: "byte order mark", cursor: encode("\uFEFF[1]"), count: 1},
+		{name: "unicode", cursor: encode(`["é"]`), count: 1, values: []any{"é"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := pageValues(tt.cursor, tt.count)
+
+			if tt.values == nil {
+				if ue, ok := errors.Cause(err).(UserError); !ok || ue.Code != "BAD_USER_INPUT" {
+					t.Fatalf("expected a BAD_USER_INPUT error, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Fatalf("expected %#v, got %#v", tt.values, values)
+			}
+		})
+	}
+}
+
+func TestPageKeys(t *testing.T) {
+	tests := []struct {
+		order string
+		keys  []pageKey
+		err   bool
+	}{
+		{order: "id", keys: []pageKey{{column: "id"}}},
+		{order: "created_at DESC, id", keys: []pageKey{{column: "created_at", desc: true}, {column: "id"}}},
+		{order: "p.name asc,p.id desc", keys: []pageKey{{column: "p.name"}, {column: "p.id", desc: true}}},
+		{order: "id sideways", err: true},
+		{order: "id,", err: true},
+		{order: "id DESC NULLS LAST", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			keys, err := pageKeys(tt.order)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", keys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Fatalf("expected %v, got %v", tt.keys, keys)
+			}
+		})
+	}
+}
+
+func TestPageWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		order  string
+		cursor string
+		before bool
+		where  string
+	}{
+		{name: "after", order: "id", cursor: `[5]`, where: `WHERE (("id" > '5'))`},
+		{name: "before", order: "id", cursor: `[5]`, before: true, where: `WHERE (("id" < '5'))`},
+		{name: "after desc", order: "id DESC", cursor: `[5]`, where: `WHERE (("id" < '5'))`},
+		{name: "mixed", order: "created_at DESC, id", cursor: `["2024-01-01T00:00:00Z",5]`, where: `WHERE (("created_at" < '2024-01-01T00:00:00Z') OR ("created_at" = '2024-01-01T00:00:00Z' AND "id" > '5'))`},
+		{name: "unicode", order: "name", cursor: `["O'Zoë"]`, where: `WHERE (("name" > 'O''Zoë'))`},
+	}
+
+	db := pageDB(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := pageKeys(tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := db.NewSelect().Model((*pageModel)(nil))
+
+			if err := pageWhere(q, keys, base64.RawURLEncoding.EncodeToString([]byte(tt.cursor)), tt.before); err != nil {
+				t.Fatal(err)
+			}
+
+			if sql := q.String(); !strings.HasSuffix(sql, tt.where) {
+				t.Fatalf("expected query ending in %s, got %s", tt.where, sql)
+			}
+		})
+	}
+}
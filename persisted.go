@@ -0,0 +1,338 @@
+package stdapp
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"go.ddollar.dev/errors"
+)
+
+const persistedQueriesSize = 1000
+
+const persistedQueriesTable = `
+CREATE TABLE IF NOT EXISTS _persisted_queries (
+	hash text PRIMARY KEY,
+	query text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+`
+
+var queryTemplate = regexp.MustCompile("(?:gql|graphql)\\s*(?:\\([^)]*\\))?\\s*`((?:[^`\\\\]|\\\\.)*)`")
+var queryInterpolation = regexp.MustCompile(`\$\{[^}]*\}`)
+
+// persistedQuery is the automatic persisted query extension: a client first
+// sends only the hash and sends the full query again when it isn't known
+type persistedQuery struct {
+	Sha256Hash string `json:"sha256Hash"`
+	Version    int    `json:"version"`
+}
+
+// queries holds persisted queries and the allow-list shared by every domain
+type queries struct {
+	allowed map[string]bool
+	store   queryStore
+}
+
+type queryStore interface {
+	Get(ctx context.Context, hash string) (string, bool, error)
+	Put(ctx context.Context, hash, query string) error
+}
+
+func (a *App) queries() (*queries, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.persisted != nil {
+		return a.persisted, nil
+	}
+
+	q := &queries{}
+
+	switch a.opts.PersistedQueries {
+	case "", "memory":
+		q.store = newMemoryQueryStore(persistedQueriesSize)
+	case "postgres":
+		q.store = &postgresQueryStore{app: a, cache: newMemoryQueryStore(persistedQueriesSize)}
+	default:
+		return nil, errors.Errorf("invalid persisted query store: %s", a.opts.PersistedQueries)
+	}
+
+	if a.opts.QueryAllowList != "" && !a.opts.Development {
+		allowed, err := a.loadAllowList(a.opts.QueryAllowList)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		q.allowed = allowed
+	}
+
+	a.persisted = q
+
+	return q, nil
+}
+
+// loadAllowList reads a manifest written by `queries extract`, from the web
+// build when it's there and otherwise from disk
+func (a *App) loadAllowList(file string) (map[string]bool, error) {
+	var data []byte
+	var err error
+
+	if a.opts.Web != nil {
+		data, err = fs.ReadFile(a.opts.Web, file)
+	}
+
+	if data == nil {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, errors.Errorf("could not read query allow-list: %s", err)
+	}
+
+	manifest := map[string]string{}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Errorf("invalid query allow-list %s: %s", file, err)
+	}
+
+	allowed := map[string]bool{}
+
+	for hash := range manifest {
+		allowed[hash] = true
+	}
+
+	return allowed, nil
+}
+
+// resolve returns the query to run for a request, fetching or storing it when
+// the request uses a persisted query and checking it against the allow-list
+func (q *queries) resolve(ctx context.Context, query string, pq *persistedQuery) (string, *gqlerrors.QueryError) {
+	if pq != nil {
+		if pq.Version != 1 {
+			return "", queryError("PERSISTED_QUERY_NOT_SUPPORTED", "unsupported persisted query version: %d", pq.Version)
+		}
+
+		if query == "" {
+			stored, ok, err := q.store.Get(ctx, pq.Sha256Hash)
+			if err != nil {
+				return "", queryError("INTERNAL_SERVER_ERROR", "could not load persisted query")
+			}
+
+			if !ok {
+				return "", queryError("PERSISTED_QUERY_NOT_FOUND", "PersistedQueryNotFound")
+			}
+
+			query = stored
+		} else if queryHash(query) != pq.Sha256Hash {
+			return "", queryError("BAD_REQUEST", "provided sha does not match query")
+		}
+	}
+
+	if !q.allows(query) {
+		return "", queryError("OPERATION_NOT_ALLOWED", "operation is not in the allow-list")
+	}
+
+	if pq != nil {
+		if err := q.store.Put(ctx, pq.Sha256Hash, query); err != nil {
+			return "", queryError("INTERNAL_SERVER_ERROR", "could not store persisted query")
+		}
+	}
+
+	return query, nil
+}
+
+// allows checks each operation and fragment separately so that clients can
+// combine them as they please, with an allow-list loaded a document that
+// doesn't parse is never allowed
+func (q *queries) allows(query string) bool {
+	if q.allowed == nil {
+		return true
+	}
+
+	defs, err := canonicalQuery(query)
+	if err != nil {
+		return false
+	}
+
+	for _, def := range defs {
+		if !q.allowed[queryHash(def)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// extractQueries collects the operations and fragments in .graphql files and
+// gql template literals under dirs, keyed by the hash the allow-list uses
+func extractQueries(dirs []string) (map[string]string, error) {
+	manifest := map[string]string{}
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				if d.Name() == "node_modules" || d.Name() == "dist" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			docs := []string{}
+
+			switch filepath.Ext(path) {
+			case ".gql", ".graphql":
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+
+				docs = append(docs, string(data))
+			case ".js", ".jsx", ".ts", ".tsx", ".vue":
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+
+				for _, m := range queryTemplate.FindAllStringSubmatch(string(data), -1) {
+					docs = append(docs, queryInterpolation.ReplaceAllString(m[1], ""))
+				}
+			}
+
+			for _, doc := range docs {
+				defs, err := canonicalQuery(doc)
+				if err != nil {
+					return errors.Errorf("%s: %s", path, err)
+				}
+
+				for _, def := range defs {
+					manifest[queryHash(def)] = def
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	return manifest, nil
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// memoryQueryStore keeps the most recently used queries in each process
+type memoryQueryStore struct {
+	items map[string]*list.Element
+	lock  sync.Mutex
+	lru   *list.List
+	size  int
+}
+
+func newMemoryQueryStore(size int) *memoryQueryStore {
+	return &memoryQueryStore{items: map[string]*list.Element{}, lru: list.New(), size: size}
+}
+
+type memoryQuery struct {
+	hash  string
+	query string
+}
+
+func (s *memoryQueryStore) Get(ctx context.Context, hash string) (string, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.items[hash]
+	if !ok {
+		return "", false, nil
+	}
+
+	s.lru.MoveToFront(e)
+
+	return e.Value.(memoryQuery).query, true, nil
+}
+
+func (s *memoryQueryStore) Put(ctx context.Context, hash, query string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e, ok := s.items[hash]; ok {
+		s.lru.MoveToFront(e)
+		return nil
+	}
+
+	s.items[hash] = s.lru.PushFront(memoryQuery{hash: hash, query: query})
+
+	for s.lru.Len() > s.size {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		delete(s.items, e.Value.(memoryQuery).hash)
+	}
+
+	return nil
+}
+
+// postgresQueryStore shares queries between replicas in the root schema and
+// keeps those it has seen in memory
+type postgresQueryStore struct {
+	app   *App
+	cache *memoryQueryStore
+}
+
+func (s *postgresQueryStore) Get(ctx context.Context, hash string) (string, bool, error) {
+	if query, ok, _ := s.cache.Get(ctx, hash); ok {
+		return query, true, nil
+	}
+
+	db, err := s.app.DB("")
+	if err != nil {
+		return "", false, errors.Wrap(err)
+	}
+
+	var query string
+
+	err = db.QueryRowContext(ctx, "SELECT query FROM _persisted_queries WHERE hash = ?", hash).Scan(&query)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err)
+	}
+
+	s.cache.Put(ctx, hash, query) //nolint:errcheck
+
+	return query, true, nil
+}
+
+func (s *postgresQueryStore) Put(ctx context.Context, hash, query string) error {
+	if _, ok, _ := s.cache.Get(ctx, hash); ok {
+		return nil
+	}
+
+	db, err := s.app.DB("")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO _persisted_queries (hash, query) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING", hash, query); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return s.cache.Put(ctx, hash, query)
+}
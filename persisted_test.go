@@ -0,0 +1,177 @@
+package stdapp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueriesAllows(t *testing.T) {
+	manifest := map[string]bool{}
+
+	for _, doc := range []string{"query Messages($first: Int) { messages(first: $first) { ...Message } }", "fragment Message on Message { id body }"} {
+		defs, err := canonicalQuery(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, def := range defs {
+			manifest[queryHash(def)] = true
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		allowed bool
+	}{
+		{name: "listed", query: "query Messages($first: Int) { messages(first: $first) { ...Message } } fragment Message on Message { id body }", allowed: true},
+		{name: "reformatted", query: "query Messages( $first : Int )\n{\n  messages(first: $first) { ...Message __typename }\n}\nfragment Message on Message { id, body }", allowed: true},
+		{name: "fragment alone", query: "fragment Message on Message { id body }", allowed: true},
+		{name: "changed", query: "query Messages($first: Int) { messages(first: $first) { ...Message author } } fragment Message on Message { id body }"},
+		{name: "extra operation", query: "query Messages($first: Int) { messages(first: $first) { ...Message } } query Users { users { id } } fragment Message on Message { id body }"},
+		{name: "unlisted", query: "{ users { password } }"},
+		{name: "syntax error", query: "{ users { password }"},
+		{name: "byte order mark", query: "\uFEFF{ users { password } }"},
+		{name: "unicode name", query: "{ é: users { password } }"},
+		{name: "byte order mark listed", query: "\uFEFFfragment Message on Message { id body }", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &queries{allowed: manifest}
+
+			if allowed := q.allows(tt.query); allowed != tt.allowed {
+				t.Fatalf("expected allowed to be %t, got %t", tt.allowed, allowed)
+			}
+		})
+	}
+
+	if !(&queries{}).allows("{ users { password }") {
+		t.Fatal("expected every document to be allowed without an allow-list")
+	}
+}
+
+func TestQueriesResolve(t *testing.T) {
+	const query = "{ a }"
+
+	tests := []struct {
+		name  string
+		query string
+		pq    *persistedQuery
+		code  string
+	}{
+		{name: "plain", query: query},
+		{name: "unknown hash", pq: &persistedQuery{Sha256Hash: queryHash("{ b }"), Version: 1}, code: "PERSISTED_QUERY_NOT_FOUND"},
+		{name: "register", query: query, pq: &persistedQuery{Sha256Hash: queryHash(query), Version: 1}},
+		{name: "registered", pq: &persistedQuery{Sha256Hash: queryHash(query), Version: 1}},
+		{name: "mismatch", query: "{ b }", pq: &persistedQuery{Sha256Hash: queryHash(query), Version: 1}, code: "BAD_REQUEST"},
+		{name: "version", query: query, pq: &persistedQuery{Sha256Hash: queryHash(query), Version: 2}, code: "PERSISTED_QUERY_NOT_SUPPORTED"},
+	}
+
+	// cases run in order against the same store
+	q := &queries{store: newMemoryQueryStore(10)}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, qerr := q.resolve(context.Background(), tt.query, tt.pq)
+
+			if tt.code != "" {
+				if qerr == nil || qerr.Extensions["code"] != tt.code {
+					t.Fatalf("expected code %q, got %v", tt.code, qerr)
+				}
+				return
+			}
+
+			if qerr != nil {
+				t.Fatal(qerr)
+			}
+
+			if resolved != query {
+				t.Fatalf("expected %q, got %q", query, resolved)
+			}
+		})
+	}
+}
+
+func TestExtractQueries(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"src/messages.graphql":         "query Messages { messages { ...Message } }\n\nfragment Message on Message { id }\n",
+		"src/users.ts":                 "const USERS = gql`\n  query Users {\n    users { id ${fields} }\n  }\n`\n",
+		"src/App.vue":                  "<script>\nconst q = graphql`mutation Touch { touch }`\n</script>\n",
+		"node_modules/x/index.graphql": "query Vendored { x }",
+		"dist/app.js":                  "gql`query Built { x }`",
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest, err := extractQueries([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"query Messages { messages { ...Message } }",
+		"fragment Message on Message { id }",
+		"query Users { users { id } }",
+		"mutation Touch { touch }",
+	}
+
+	if len(manifest) != len(expected) {
+		t.Fatalf("expected %d definitions, got %q", len(expected), manifest)
+	}
+
+	for _, def := range expected {
+		if manifest[queryHash(def)] != def {
+			t.Fatalf("expected %q in %q", def, manifest)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "src/broken.graphql"), []byte("query {"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := extractQueries([]string{dir}); err == nil {
+		t.Fatal("expected an error for a document that doesn't parse")
+	}
+}
+
+func TestMemoryQueryStore(t *testing.T) {
+	ctx := context.Background()
+
+	s := newMemoryQueryStore(2)
+
+	for _, hash := range []string{"a", "b"} {
+		if err := s.Put(ctx, hash, "query "+hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a is now the most recently used, so adding c evicts b
+	if _, ok, _ := s.Get(ctx, "a"); !ok {
+		t.Fatal("expected a to be stored")
+	}
+
+	if err := s.Put(ctx, "c", "query c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for hash, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := s.Get(ctx, hash); ok != expected {
+			t.Fatalf("expected %s stored to be %t", hash, expected)
+		}
+	}
+}
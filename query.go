@@ -0,0 +1,508 @@
+package stdapp
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"go.ddollar.dev/errors"
+)
+
+// parseQuery parses an executable document into graphql-go's ast with the
+// same scanner and grammar graphql-go runs documents through before executing
+// them, which it doesn't export. The only difference is that a description
+// before a variable, which graphql-go skips, is a syntax error here, so a
+// document the two disagree on is never measured as smaller than it is.
+func parseQuery(src string) (doc *ast.ExecutableDefinition, qerr *gqlerrors.QueryError) {
+	p := &queryParser{sc: &scanner.Scanner{Mode: scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings}}
+
+	p.sc.Init(strings.NewReader(src))
+	p.sc.Error = func(_ *scanner.Scanner, msg string) { panic(querySyntaxError(msg)) }
+
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(querySyntaxError)
+			if !ok {
+				panic(r)
+			}
+
+			qerr = gqlerrors.Errorf("syntax error: %s", msg)
+			qerr.Locations = []gqlerrors.Location{p.location()}
+		}
+	}()
+
+	p.next()
+
+	return p.document(), nil
+}
+
+type querySyntaxError string
+
+type queryParser struct {
+	sc    *scanner.Scanner
+	token rune
+}
+
+// next moves to the next token, skipping commas and comments
+func (p *queryParser) next() {
+	for {
+		p.token = p.sc.Scan()
+
+		switch p.token {
+		case ',':
+			continue
+		case '#':
+			for c := p.sc.Peek(); c != '\r' && c != '\n' && c != scanner.EOF; c = p.sc.Peek() {
+				p.sc.Next()
+			}
+			continue
+		}
+
+		return
+	}
+}
+
+func (p *queryParser) location() gqlerrors.Location {
+	return gqlerrors.Location{Line: p.sc.Line, Column: p.sc.Column}
+}
+
+func (p *queryParser) fail(format string, args ...any) {
+	panic(querySyntaxError(fmt.Sprintf(format, args...)))
+}
+
+func (p *queryParser) expect(token rune) {
+	if p.token != token {
+		p.fail("unexpected %q, expecting %s", p.sc.TokenText(), scanner.TokenString(token))
+	}
+
+	p.next()
+}
+
+func (p *queryParser) ident() ast.Ident {
+	id := ast.Ident{Name: p.sc.TokenText(), Loc: p.location()}
+
+	p.expect(scanner.Ident)
+
+	return id
+}
+
+func (p *queryParser) document() *ast.ExecutableDefinition {
+	doc := &ast.ExecutableDefinition{}
+
+	for p.token != scanner.EOF {
+		loc := p.location()
+
+		if p.token == '{' {
+			doc.Operations = append(doc.Operations, &ast.OperationDefinition{Type: "QUERY", Selections: p.selections(), Loc: loc})
+			continue
+		}
+
+		switch name := p.ident().Name; name {
+		case "query", "mutation", "subscription":
+			op := p.operation(ast.OperationType(strings.ToUpper(name)))
+			op.Loc = loc
+			doc.Operations = append(doc.Operations, op)
+		case "fragment":
+			f := &ast.FragmentDefinition{Name: p.ident(), Loc: loc}
+
+			if p.token != scanner.Ident || p.sc.TokenText() != "on" {
+				p.fail("unexpected %q, expecting %q", p.sc.TokenText(), "on")
+			}
+
+			p.next()
+
+			f.On = ast.TypeName{Ident: p.ident()}
+			f.Directives = p.directives()
+			f.Selections = p.selections()
+
+			doc.Fragments = append(doc.Fragments, f)
+		default:
+			p.fail("unexpected %q, expecting %q", name, "fragment")
+		}
+	}
+
+	return doc
+}
+
+func (p *queryParser) operation(kind ast.OperationType) *ast.OperationDefinition {
+	op := &ast.OperationDefinition{Type: kind}
+
+	if p.token == scanner.Ident {
+		op.Name = p.ident()
+	}
+
+	op.Directives = p.directives()
+
+	if p.token == '(' {
+		p.next()
+
+		for p.token != ')' {
+			v := &ast.InputValueDefinition{Loc: p.location()}
+
+			p.expect('$')
+
+			v.Name = p.ident()
+
+			p.expect(':')
+
+			v.TypeLoc = p.location()
+			v.Type = p.typ()
+
+			if p.token == '=' {
+				p.next()
+				v.Default = p.value(true)
+			}
+
+			v.Directives = p.directives()
+
+			op.Vars = append(op.Vars, v)
+		}
+
+		p.next()
+	}
+
+	op.Selections = p.selections()
+
+	return op
+}
+
+func (p *queryParser) selections() ast.SelectionSet {
+	sels := ast.SelectionSet{}
+
+	p.expect('{')
+
+	for p.token != '}' {
+		if p.token == '.' {
+			sels = append(sels, p.spread())
+			continue
+		}
+
+		f := &ast.Field{Alias: p.ident()}
+		f.Name = f.Alias
+
+		if p.token == ':' {
+			p.next()
+			f.Name = p.ident()
+		}
+
+		if p.token == '(' {
+			f.Arguments = p.arguments()
+		}
+
+		f.Directives = p.directives()
+
+		if p.token == '{' {
+			f.SelectionSetLoc = p.location()
+			f.SelectionSet = p.selections()
+		}
+
+		sels = append(sels, f)
+	}
+
+	p.next()
+
+	return sels
+}
+
+func (p *queryParser) spread() ast.Selection {
+	loc := p.location()
+
+	p.expect('.')
+	p.expect('.')
+	p.expect('.')
+
+	f := &ast.InlineFragment{Loc: loc}
+
+	if p.token == scanner.Ident {
+		id := p.ident()
+
+		if id.Name != "on" {
+			return &ast.FragmentSpread{Name: id, Directives: p.directives(), Loc: loc}
+		}
+
+		f.On = ast.TypeName{Ident: p.ident()}
+	}
+
+	f.Directives = p.directives()
+	f.Selections = p.selections()
+
+	return f
+}
+
+func (p *queryParser) arguments() ast.ArgumentList {
+	args := ast.ArgumentList{}
+
+	p.expect('(')
+
+	for p.token != ')' {
+		arg := &ast.Argument{Name: p.ident()}
+
+		p.expect(':')
+
+		arg.Value = p.value(false)
+		arg.Directives = p.directives()
+
+		args = append(args, arg)
+	}
+
+	p.next()
+
+	return args
+}
+
+func (p *queryParser) directives() ast.DirectiveList {
+	var ds ast.DirectiveList
+
+	for p.token == '@' {
+		p.next()
+
+		d := &ast.Directive{Name: p.ident()}
+		d.Name.Loc.Column--
+
+		if p.token == '(' {
+			d.Arguments = p.arguments()
+		}
+
+		ds = append(ds, d)
+	}
+
+	return ds
+}
+
+func (p *queryParser) typ() ast.Type {
+	var t ast.Type
+
+	if p.token == '[' {
+		p.next()
+		t = &ast.List{OfType: p.typ()}
+		p.expect(']')
+	} else {
+		t = &ast.TypeName{Ident: p.ident()}
+	}
+
+	if p.token == '!' {
+		p.next()
+		return &ast.NonNull{OfType: t}
+	}
+
+	return t
+}
+
+func (p *queryParser) value(constant bool) ast.Value {
+	loc := p.location()
+
+	switch p.token {
+	case '$':
+		if constant {
+			p.fail("variable not allowed")
+		}
+
+		p.next()
+
+		return &ast.Variable{Name: p.ident().Name, Loc: loc}
+	case scanner.Int, scanner.Float, scanner.String, scanner.Ident:
+		v := &ast.PrimitiveValue{Type: p.token, Text: p.sc.TokenText(), Loc: loc}
+
+		p.next()
+
+		if v.Type == scanner.Ident && v.Text == "null" {
+			return &ast.NullValue{Loc: loc}
+		}
+
+		return v
+	case '-':
+		p.next()
+
+		v := &ast.PrimitiveValue{Type: p.token, Text: "-" + p.sc.TokenText(), Loc: loc}
+
+		p.next()
+
+		return v
+	case '[':
+		p.next()
+
+		list := &ast.ListValue{Loc: loc}
+
+		for p.token != ']' {
+			list.Values = append(list.Values, p.value(constant))
+		}
+
+		p.next()
+
+		return list
+	case '{':
+		p.next()
+
+		obj := &ast.ObjectValue{Loc: loc}
+
+		for p.token != '}' {
+			f := &ast.ObjectField{Name: p.ident()}
+
+			p.expect(':')
+
+			f.Value = p.value(constant)
+
+			obj.Fields = append(obj.Fields, f)
+		}
+
+		p.next()
+
+		return obj
+	default:
+		p.fail("invalid value")
+		return nil
+	}
+}
+
+// queryOperation picks the operation to run the way graphql-go does, nil
+// when the choice is ambiguous
+func queryOperation(doc *ast.ExecutableDefinition, name string) *ast.OperationDefinition {
+	if name == "" {
+		if len(doc.Operations) == 1 {
+			return doc.Operations[0]
+		}
+		return nil
+	}
+
+	return doc.Operations.Get(name)
+}
+
+// operationType is the name the schema gives the root type of op: query,
+// mutation or subscription
+func operationType(op *ast.OperationDefinition) string {
+	return strings.ToLower(string(op.Type))
+}
+
+// canonicalQuery splits a document into its operations and fragments, each
+// printed on one line with single spaces. __typename is dropped as clients
+// like Apollo add it to documents before sending them.
+func canonicalQuery(src string) ([]string, error) {
+	doc, qerr := parseQuery(src)
+	if qerr != nil {
+		return nil, errors.Errorf("%s", qerr.Message)
+	}
+
+	defs := []string{}
+
+	for _, op := range doc.Operations {
+		var b strings.Builder
+
+		b.WriteString(operationType(op))
+
+		if op.Name.Name != "" {
+			fmt.Fprintf(&b, " %s", op.Name.Name)
+		}
+
+		if len(op.Vars) > 0 {
+			vars := make([]string, len(op.Vars))
+
+			for i, v := range op.Vars {
+				vars[i] = fmt.Sprintf("$%s: %s", v.Name.Name, queryType(v.Type))
+
+				if v.Default != nil {
+					vars[i] += " = " + v.Default.String()
+				}
+			}
+
+			fmt.Fprintf(&b, "(%s)", strings.Join(vars, ", "))
+		}
+
+		writeDirectives(&b, op.Directives)
+		writeSelections(&b, op.Selections)
+
+		defs = append(defs, b.String())
+	}
+
+	for _, f := range doc.Fragments {
+		var b strings.Builder
+
+		fmt.Fprintf(&b, "fragment %s on %s", f.Name.Name, f.On.Name)
+
+		writeDirectives(&b, f.Directives)
+		writeSelections(&b, f.Selections)
+
+		defs = append(defs, b.String())
+	}
+
+	return defs, nil
+}
+
+func writeSelections(b *strings.Builder, sels ast.SelectionSet) {
+	b.WriteString(" {")
+
+	for _, sel := range sels {
+		switch s := sel.(type) {
+		case *ast.Field:
+			if s.Name.Name == "__typename" && s.Alias.Name == s.Name.Name {
+				continue
+			}
+
+			b.WriteString(" ")
+
+			if s.Alias.Name != s.Name.Name {
+				fmt.Fprintf(b, "%s: ", s.Alias.Name)
+			}
+
+			b.WriteString(s.Name.Name)
+
+			writeArguments(b, s.Arguments)
+			writeDirectives(b, s.Directives)
+
+			if len(s.SelectionSet) > 0 {
+				writeSelections(b, s.SelectionSet)
+			}
+		case *ast.FragmentSpread:
+			fmt.Fprintf(b, " ...%s", s.Name.Name)
+			writeDirectives(b, s.Directives)
+		case *ast.InlineFragment:
+			b.WriteString(" ...")
+
+			if s.On.Name != "" {
+				fmt.Fprintf(b, " on %s", s.On.Name)
+			}
+
+			writeDirectives(b, s.Directives)
+			writeSelections(b, s.Selections)
+		}
+	}
+
+	b.WriteString(" }")
+}
+
+func writeArguments(b *strings.Builder, args ast.ArgumentList) {
+	if len(args) == 0 {
+		return
+	}
+
+	parts := make([]string, len(args))
+
+	for i, arg := range args {
+		parts[i] = fmt.Sprintf("%s: %s", arg.Name.Name, arg.Value.String())
+	}
+
+	fmt.Fprintf(b, "(%s)", strings.Join(parts, ", "))
+}
+
+func writeDirectives(b *strings.Builder, ds ast.DirectiveList) {
+	for _, d := range ds {
+		fmt.Fprintf(b, " @%s", d.Name.Name)
+		writeArguments(b, d.Arguments)
+	}
+}
+
+// queryType prints the type of a variable, which graphql-go leaves as names
+// that haven't been resolved against the schema
+func queryType(t ast.Type) string {
+	switch w := t.(type) {
+	case *ast.List:
+		return "[" + queryType(w.OfType) + "]"
+	case *ast.NonNull:
+		return queryType(w.OfType) + "!"
+	case *ast.TypeName:
+		return w.Name
+	default:
+		return fmt.Sprint(t)
+	}
+}
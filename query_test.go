@@ -0,0 +1,141 @@
+package stdapp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		defs     []string
+		err      bool
+	}{
+		{
+			name:     "shorthand",
+			document: "{ a }",
+			defs:     []string{"query { a }"},
+		},
+		{
+			name:     "whitespace and comments",
+			document: "query   X\n{\n  # list\n  a ,\n  b\n}",
+			defs:     []string{"query X { a b }"},
+		},
+		{
+			name:     "variables and arguments",
+			document: `query X($n: Int = 10, $ids: [ID!]!) { items(first: $n, ids: $ids, where: {name: "x", tags: ["a", "b"]}) { id } }`,
+			defs:     []string{`query X($n: Int = 10, $ids: [ID!]!) { items(first: $n, ids: $ids, where: {name: "x", tags: ["a", "b"]}) { id } }`},
+		},
+		{
+			name:     "aliases and directives",
+			document: "query X @cached { first: a @include(if: true) { b } }",
+			defs:     []string{"query X @cached { first: a @include(if: true) { b } }"},
+		},
+		{
+			name:     "typename dropped",
+			document: "{ a { __typename b } }",
+			defs:     []string{"query { a { b } }"},
+		},
+		{
+			name:     "aliased typename kept",
+			document: "{ a { kind: __typename b } }",
+			defs:     []string{"query { a { kind: __typename b } }"},
+		},
+		{
+			name:     "fragments",
+			document: "mutation M { a { ...F ... on B { c } ... @skip(if: false) { d } } } fragment F on A { e }",
+			defs:     []string{"mutation M { a { ...F ... on B { c } ... @skip(if: false) { d } } }", "fragment F on A { e }"},
+		},
+		{
+			name:     "subscription",
+			document: "subscription S { changed { id } }",
+			defs:     []string{"subscription S { changed { id } }"},
+		},
+		{
+			name:     "byte order mark",
+			document: "\uFEFF{ a }",
+			defs:     []string{"query { a }"},
+		},
+		{
+			name:     "unicode name",
+			document: "{ é: a }",
+			defs:     []string{"query { é: a }"},
+		},
+		{
+			name:     "empty",
+			document: "",
+			defs:     []string{},
+		},
+		{
+			name:     "unbalanced",
+			document: "{ a { b }",
+			err:      true,
+		},
+		{
+			name:     "unknown definition",
+			document: "type A { b: Int }",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs, err := canonicalQuery(tt.document)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", defs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(defs, tt.defs) {
+				t.Fatalf("expected %q, got %q", tt.defs, defs)
+			}
+		})
+	}
+}
+
+func TestQueryOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		operation string
+		expected  string
+		kind      string
+	}{
+		{name: "only", document: "{ a }", expected: "", kind: "query"},
+		{name: "named", document: "query X { a } mutation Y { b }", operation: "Y", expected: "Y", kind: "mutation"},
+		{name: "ambiguous", document: "query X { a } query Y { b }"},
+		{name: "missing", document: "query X { a }", operation: "Y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, qerr := parseQuery(tt.document)
+			if qerr != nil {
+				t.Fatal(qerr)
+			}
+
+			op := queryOperation(doc, tt.operation)
+
+			if tt.kind == "" {
+				if op != nil {
+					t.Fatalf("expected no operation, got %q", op.Name.Name)
+				}
+				return
+			}
+
+			if op == nil {
+				t.Fatal("expected an operation")
+			}
+
+			if op.Name.Name != tt.expected || operationType(op) != tt.kind {
+				t.Fatalf("expected %s %q, got %s %q", tt.kind, tt.expected, operationType(op), op.Name.Name)
+			}
+		})
+	}
+}
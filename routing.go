@@ -0,0 +1,122 @@
+package stdapp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DomainResolverFunc picks the domain that serves a request to /api/graph,
+// returning an empty string when the request doesn't belong to any
+type DomainResolverFunc func(r *http.Request) string
+
+// DomainDefault always picks domain, such as for an application with a
+// single domain or as the last of DomainResolvers
+func DomainDefault(domain string) DomainResolverFunc {
+	return func(r *http.Request) string {
+		return domain
+	}
+}
+
+// DomainFromHeader takes the domain from a request header. Browsers can't
+// set headers on a websocket, so subscriptions from a browser need one of
+// the host strategies.
+func DomainFromHeader(name string) DomainResolverFunc {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// DomainFromHost maps each of a set of hostnames to a domain
+func DomainFromHost(hosts map[string]string) DomainResolverFunc {
+	lower := map[string]string{}
+
+	for host, domain := range hosts {
+		lower[strings.ToLower(host)] = domain
+	}
+
+	return func(r *http.Request) string {
+		return lower[requestHost(r)]
+	}
+}
+
+// DomainFromSubdomain takes the domain from the label in front of base, so
+// that with a base of example.com acme.example.com is served by acme
+func DomainFromSubdomain(base string) DomainResolverFunc {
+	suffix := "." + strings.ToLower(strings.Trim(base, "."))
+
+	return func(r *http.Request) string {
+		label, ok := strings.CutSuffix(requestHost(r), suffix)
+		if !ok || strings.Contains(label, ".") {
+			return ""
+		}
+
+		return label
+	}
+}
+
+// DomainResolvers tries each resolver in turn and uses the first domain
+// found
+func DomainResolvers(resolvers ...DomainResolverFunc) DomainResolverFunc {
+	return func(r *http.Request) string {
+		for _, resolver := range resolvers {
+			if domain := resolver(r); domain != "" {
+				return domain
+			}
+		}
+
+		return ""
+	}
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// domainResolver serves /api/graph from whichever static or registered
+// domain Options.DomainResolver picks for the request
+type domainResolver struct {
+	router *domainRouter
+}
+
+func (dr domainResolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app := dr.router.app
+
+	endpoint := fmt.Sprintf("%s/api/graph", app.opts.Prefix)
+
+	// preflight requests don't carry the headers a resolver might look at
+	if r.Method == "OPTIONS" {
+		graphCORS(w)
+
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+
+		fmt.Fprintf(w, "ok\n")
+		return
+	}
+
+	domain := app.opts.DomainResolver(r)
+
+	e, ok := dr.router.endpoint(domain)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, endpoint), "/")
+
+	if rest == "graphiql" && r.Method == "GET" && e.graphiql != nil {
+		wrapMiddleware(app.graphiql(domain, endpoint), app.domainConfig(domain).Middleware).ServeHTTP(w, r)
+		return
+	}
+
+	e.serve(w, r, rest)
+}
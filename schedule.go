@@ -0,0 +1,379 @@
+package stdapp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+	"go.ddollar.dev/errors"
+)
+
+const electionInterval = 5 * time.Second
+
+const schedulesTable = `
+CREATE TABLE IF NOT EXISTS _schedules (
+	name text NOT NULL,
+	domain text NOT NULL,
+	started_at timestamptz NOT NULL,
+	finished_at timestamptz,
+	error text,
+	PRIMARY KEY (name, domain)
+);
+`
+
+type Schedule struct {
+	Domain bool
+	Func   ScheduleFunc
+	Name   string
+	Spec   string
+}
+
+// ScheduleFunc receives each domain's database in turn when Schedule.Domain is
+// set, and otherwise the root database and an empty domain
+type ScheduleFunc func(ctx context.Context, db *bun.DB, domain string) error
+
+type scheduleResult struct {
+	Domain   string
+	Error    string
+	Finished *time.Time
+	Name     string
+	Started  time.Time
+}
+
+func (a *App) schedules() (map[string]cron.Schedule, error) {
+	specs := map[string]cron.Schedule{}
+
+	for _, s := range a.opts.Schedules {
+		if s.Name == "" {
+			return nil, errors.Errorf("schedule has no name: %s", s.Spec)
+		}
+
+		if _, ok := specs[s.Name]; ok {
+			return nil, errors.Errorf("duplicate schedule: %s", s.Name)
+		}
+
+		spec, err := cron.ParseStandard(s.Spec)
+		if err != nil {
+			return nil, errors.Errorf("invalid schedule %s: %s", s.Name, err)
+		}
+
+		specs[s.Name] = spec
+	}
+
+	return specs, nil
+}
+
+// schedule fires every schedule while this process holds the scheduler lock,
+// then waits up to drain for running schedules after ctx is cancelled
+func (a *App) schedule(ctx context.Context, drain time.Duration) error {
+	specs, err := a.schedules()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if len(specs) == 0 {
+		return errors.Errorf("no schedules configured")
+	}
+
+	if err := a.prepare(ctx, ""); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := a.loadDomains(ctx); err != nil {
+		return errors.Wrap(err)
+	}
+
+	go a.watchDomains(ctx, nil)
+
+	var leading atomic.Bool
+
+	ectx, ecancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer ecancel()
+
+	elected := make(chan struct{})
+
+	go func() {
+		defer close(elected)
+		a.elect(ectx, &leading)
+	}()
+
+	jctx, jcancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer jcancel()
+
+	c := cron.New()
+
+	for _, s := range a.opts.Schedules {
+		c.Schedule(specs[s.Name], a.scheduleJob(jctx, s, &leading))
+	}
+
+	c.Start()
+
+	a.logger.At("scheduler").Logf("schedules=%d", len(specs))
+
+	<-ctx.Done()
+
+	a.logger.At("scheduler").Logf("state=draining timeout=%s", drain)
+
+	select {
+	case <-c.Stop().Done():
+	case <-time.After(drain):
+		jcancel()
+		err = errors.Errorf("schedules still running after %s", drain)
+	}
+
+	// keep the lock until running schedules finish so another replica can't start them again
+	ecancel()
+	<-elected
+
+	if err != nil {
+		return err
+	}
+
+	a.logger.At("scheduler").Logf("state=complete")
+
+	return nil
+}
+
+func (a *App) scheduleJob(ctx context.Context, s Schedule, leading *atomic.Bool) cron.Job {
+	var running atomic.Bool
+
+	return cron.FuncJob(func() {
+		if !leading.Load() {
+			return
+		}
+
+		if !running.CompareAndSwap(false, true) {
+			a.logger.At("schedule").Logf("name=%s state=skipped reason=running", s.Name)
+			return
+		}
+		defer running.Store(false)
+
+		domains := []string{""}
+
+		if s.Domain {
+			domains = a.domains()
+		}
+
+		var wg sync.WaitGroup
+
+		for _, domain := range domains {
+			wg.Add(1)
+
+			go func(domain string) {
+				defer wg.Done()
+				a.runSchedule(ctx, s, domain)
+			}(domain)
+		}
+
+		wg.Wait()
+	})
+}
+
+func (a *App) runSchedule(ctx context.Context, s Schedule, domain string) {
+	log := a.logger.At("schedule").Append("name=%s domain=%s", s.Name, domain).Start()
+
+	if err := a.recordSchedule(ctx, s.Name, domain, nil, false); err != nil {
+		log.Error(err) //nolint:errcheck
+	}
+
+	err := a.callSchedule(ctx, s, domain)
+
+	if err != nil {
+		log.Error(err) //nolint:errcheck
+	} else {
+		log.Success() //nolint:errcheck
+	}
+
+	if err := a.recordSchedule(ctx, s.Name, domain, err, true); err != nil {
+		log.Error(err) //nolint:errcheck
+	}
+}
+
+func (a *App) callSchedule(ctx context.Context, s Schedule, domain string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+
+	db, err := a.DB(domain)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return s.Func(ctx, db, domain)
+}
+
+func (a *App) recordSchedule(ctx context.Context, name, domain string, serr error, finished bool) error {
+	db, err := a.DB("")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if !finished {
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO _schedules (name, domain, started_at) VALUES (?, ?, now())
+			ON CONFLICT (name, domain) DO UPDATE SET started_at = now(), finished_at = NULL, error = NULL
+		`, name, domain)
+	} else {
+		var message any
+
+		if serr != nil {
+			message = serr.Error()
+		}
+
+		_, err = db.ExecContext(ctx, `
+			UPDATE _schedules SET finished_at = now(), error = ? WHERE name = ? AND domain = ?
+		`, message, name, domain)
+	}
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (a *App) scheduleResults(ctx context.Context) (map[string]scheduleResult, error) {
+	db, err := a.DB("")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	results := map[string]scheduleResult{}
+
+	var exists bool
+
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('_schedules') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if !exists {
+		return results, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name, domain, started_at, finished_at, coalesce(error, '') FROM _schedules")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r scheduleResult
+
+		if err := rows.Scan(&r.Name, &r.Domain, &r.Started, &r.Finished, &r.Error); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		results[fmt.Sprintf("%s/%s", r.Name, r.Domain)] = r
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return results, nil
+}
+
+func (r scheduleResult) String() string {
+	switch {
+	case r.Started.IsZero():
+		return "never"
+	case r.Finished == nil:
+		return "running"
+	case r.Error != "":
+		return fmt.Sprintf("error: %s", r.Error)
+	default:
+		return "ok"
+	}
+}
+
+// elect keeps trying to take a session advisory lock on a dedicated connection,
+// only the replica holding it fires schedules
+func (a *App) elect(ctx context.Context, leading *atomic.Bool) {
+	var conn *sql.Conn
+
+	key := fmt.Sprintf("stdapp:scheduler:%s:%s", a.opts.Name, a.Schema(""))
+
+	defer func() {
+		leading.Store(false)
+
+		if conn != nil {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", key); err != nil {
+				discardConn(conn)
+			} else {
+				conn.Close()
+			}
+		}
+	}()
+
+	for {
+		held, err := a.electOnce(ctx, &conn, key, leading.Load())
+		if err != nil && ctx.Err() == nil {
+			a.logger.At("elect").Logf("error=%q", err)
+		}
+
+		if held != leading.Load() {
+			a.logger.At("elect").Logf("leader=%t", held)
+			leading.Store(held)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(electionInterval):
+		}
+	}
+}
+
+func (a *App) electOnce(ctx context.Context, conn **sql.Conn, key string, leading bool) (bool, error) {
+	if *conn == nil {
+		sdb, err := a.database()
+		if err != nil {
+			return false, errors.Wrap(err)
+		}
+
+		c, err := sdb.Conn(ctx)
+		if err != nil {
+			return false, errors.Wrap(err)
+		}
+
+		*conn = c
+	}
+
+	query := "SELECT pg_try_advisory_lock(hashtext($1))"
+
+	// taking the lock again would stack it, so a leader checks that its
+	// session still holds it instead, a bigint key is split over classid and
+	// objid
+	if leading {
+		query = `
+			SELECT EXISTS (
+				SELECT 1 FROM pg_locks
+				WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND granted AND objsubid = 1
+				AND ((classid::bigint << 32) | objid::bigint) = hashtext($1)::bigint
+			)
+		`
+	}
+
+	var held bool
+
+	if err := (*conn).QueryRowContext(ctx, query, key).Scan(&held); err != nil {
+		discardConn(*conn)
+		*conn = nil
+		return false, errors.Wrap(err)
+	}
+
+	return held, nil
+}
+
+// discardConn closes a connection rather than returning it to the pool, so
+// that the session ends along with any advisory lock it might still hold
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(any) error { return driver.ErrBadConn }) //nolint:errcheck
+}
@@ -0,0 +1,582 @@
+package stdapp
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"go.ddollar.dev/errors"
+)
+
+var builtinDirectives = map[string]bool{"deprecated": true, "include": true, "oneOf": true, "skip": true, "specifiedBy": true}
+
+var builtinScalars = map[string]bool{"Boolean": true, "Float": true, "ID": true, "Int": true, "String": true}
+
+// introspection mirrors the output of graphql.Schema.ToJSON
+type introspection struct {
+	Schema struct {
+		Directives       []introspectionDirective `json:"directives"`
+		MutationType     *introspectionRef        `json:"mutationType"`
+		QueryType        *introspectionRef        `json:"queryType"`
+		SubscriptionType *introspectionRef        `json:"subscriptionType"`
+		Types            []introspectionType      `json:"types"`
+	} `json:"__schema"`
+}
+
+type introspectionDirective struct {
+	Args        []introspectionInput `json:"args"`
+	Description string               `json:"description"`
+	Locations   []string             `json:"locations"`
+	Name        string               `json:"name"`
+}
+
+type introspectionEnum struct {
+	DeprecationReason *string `json:"deprecationReason"`
+	Description       string  `json:"description"`
+	IsDeprecated      bool    `json:"isDeprecated"`
+	Name              string  `json:"name"`
+}
+
+type introspectionField struct {
+	Args              []introspectionInput `json:"args"`
+	DeprecationReason *string              `json:"deprecationReason"`
+	Description       string               `json:"description"`
+	IsDeprecated      bool                 `json:"isDeprecated"`
+	Name              string               `json:"name"`
+	Type              introspectionRef     `json:"type"`
+}
+
+type introspectionInput struct {
+	DefaultValue *string          `json:"defaultValue"`
+	Description  string           `json:"description"`
+	Name         string           `json:"name"`
+	Type         introspectionRef `json:"type"`
+}
+
+type introspectionRef struct {
+	Kind   string            `json:"kind"`
+	Name   string            `json:"name"`
+	OfType *introspectionRef `json:"ofType"`
+}
+
+type introspectionType struct {
+	Description   string               `json:"description"`
+	EnumValues    []introspectionEnum  `json:"enumValues"`
+	Fields        []introspectionField `json:"fields"`
+	InputFields   []introspectionInput `json:"inputFields"`
+	Interfaces    []introspectionRef   `json:"interfaces"`
+	Kind          string               `json:"kind"`
+	Name          string               `json:"name"`
+	PossibleTypes []introspectionRef   `json:"possibleTypes"`
+}
+
+type schemaChange struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+}
+
+func (a *App) graphSchema(domain string) (*graphql.Schema, error) {
+	resolver := a.domainConfig(domain).Resolver
+
+	if resolver == nil {
+		return nil, errors.Errorf("no resolver configured")
+	}
+
+	db, err := a.schemaDB(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	r, err := resolver(db, domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	s, err := graphql.ParseSchema(r.Schema(), r)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return s, nil
+}
+
+// schemaDB lets the schema commands build resolvers without a database
+// configured, the connection is never opened
+func (a *App) schemaDB(domain string) (*bun.DB, error) {
+	if a.opts.Database != "" {
+		return a.DB(domain)
+	}
+
+	return bun.NewDB(sql.OpenDB(pgdriver.NewConnector()), pgdialect.New()), nil
+}
+
+// dumpSchema writes <domain>.graphql and <domain>.json to dir
+func (a *App) dumpSchema(domain, dir string) ([]string, error) {
+	s, err := a.graphSchema(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	data, err := s.ToJSON()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var is introspection
+
+	if err := json.Unmarshal(data, &is); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	files := map[string][]byte{
+		filepath.Join(dir, domain+".graphql"): []byte(is.sdl()),
+		filepath.Join(dir, domain+".json"):    append(data, '\n'),
+	}
+
+	written := []string{}
+
+	for file, data := range files {
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		written = append(written, file)
+	}
+
+	sort.Strings(written)
+
+	return written, nil
+}
+
+// checkSchema compares the domain's current schema to a previous one read
+// from an introspection json or sdl file
+func (a *App) checkSchema(domain, file string) ([]schemaChange, error) {
+	s, err := a.graphSchema(domain)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	data, err := s.ToJSON()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	current, err := loadIntrospection(data)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	data, err = os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	previous, err := loadIntrospection(data)
+	if err != nil {
+		return nil, errors.Errorf("invalid schema %s: %s", file, err)
+	}
+
+	return breakingChanges(previous, current), nil
+}
+
+func loadIntrospection(data []byte) (*introspection, error) {
+	// a byte order mark from the editor would otherwise make valid json look like sdl
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+
+	if !json.Valid(data) {
+		s, err := graphql.ParseSchema(string(data), nil)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		if data, err = s.ToJSON(); err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	var is introspection
+
+	if err := json.Unmarshal(data, &is); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &is, nil
+}
+
+func breakingChanges(previous, current *introspection) []schemaChange {
+	changes := []schemaChange{}
+
+	change := func(path, format string, args ...any) {
+		changes = append(changes, schemaChange{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	roots := []struct {
+		name     string
+		previous *introspectionRef
+		current  *introspectionRef
+	}{
+		{"query", previous.Schema.QueryType, current.Schema.QueryType},
+		{"mutation", previous.Schema.MutationType, current.Schema.MutationType},
+		{"subscription", previous.Schema.SubscriptionType, current.Schema.SubscriptionType},
+	}
+
+	for _, r := range roots {
+		switch {
+		case r.previous == nil:
+		case r.current == nil:
+			change("schema."+r.name, "root type removed")
+		case r.previous.Name != r.current.Name:
+			change("schema."+r.name, "root type changed from %s to %s", r.previous.Name, r.current.Name)
+		}
+	}
+
+	types := current.types()
+
+	for _, pt := range previous.Schema.Types {
+		if strings.HasPrefix(pt.Name, "__") {
+			continue
+		}
+
+		ct, ok := types[pt.Name]
+		if !ok {
+			change(pt.Name, "type removed")
+			continue
+		}
+
+		if pt.Kind != ct.Kind {
+			change(pt.Name, "kind changed from %s to %s", pt.Kind, ct.Kind)
+			continue
+		}
+
+		switch pt.Kind {
+		case "ENUM":
+			values := map[string]bool{}
+
+			for _, v := range ct.EnumValues {
+				values[v.Name] = true
+			}
+
+			for _, v := range pt.EnumValues {
+				if !values[v.Name] {
+					change(pt.Name+"."+v.Name, "enum value removed")
+				}
+			}
+		case "INPUT_OBJECT":
+			compareInputs(change, pt.Name, pt.InputFields, ct.InputFields, "input field")
+		case "INTERFACE", "OBJECT":
+			fields := map[string]introspectionField{}
+
+			for _, f := range ct.Fields {
+				fields[f.Name] = f
+			}
+
+			for _, pf := range pt.Fields {
+				path := pt.Name + "." + pf.Name
+
+				cf, ok := fields[pf.Name]
+				if !ok {
+					change(path, "field removed")
+					continue
+				}
+
+				if !safeOutput(pf.Type, cf.Type) {
+					change(path, "type changed from %s to %s", pf.Type, cf.Type)
+				}
+
+				compareInputs(change, path, pf.Args, cf.Args, "argument")
+			}
+
+			interfaces := map[string]bool{}
+
+			for _, i := range ct.Interfaces {
+				interfaces[i.Name] = true
+			}
+
+			for _, i := range pt.Interfaces {
+				if !interfaces[i.Name] {
+					change(pt.Name, "no longer implements %s", i.Name)
+				}
+			}
+		case "UNION":
+			members := map[string]bool{}
+
+			for _, m := range ct.PossibleTypes {
+				members[m.Name] = true
+			}
+
+			for _, m := range pt.PossibleTypes {
+				if !members[m.Name] {
+					change(pt.Name, "union member %s removed", m.Name)
+				}
+			}
+		}
+	}
+
+	return changes
+}
+
+func compareInputs(change func(path, format string, args ...any), path string, previous, current []introspectionInput, kind string) {
+	existing := map[string]introspectionInput{}
+
+	for _, p := range previous {
+		existing[p.Name] = p
+	}
+
+	inputs := map[string]bool{}
+
+	for _, c := range current {
+		inputs[c.Name] = true
+
+		p, ok := existing[c.Name]
+
+		switch {
+		case !ok && c.Type.Kind == "NON_NULL" && c.DefaultValue == nil:
+			change(path+"."+c.Name, "required %s added", kind)
+		case ok && !safeInput(p.Type, c.Type):
+			change(path+"."+c.Name, "type changed from %s to %s", p.Type, c.Type)
+		}
+	}
+
+	for _, p := range previous {
+		if !inputs[p.Name] {
+			change(path+"."+p.Name, "%s removed", kind)
+		}
+	}
+}
+
+// safeOutput allows an output type to become non-null, clients always receive a value they expect
+func safeOutput(previous, current introspectionRef) bool {
+	if current.Kind == "NON_NULL" && previous.Kind != "NON_NULL" {
+		return safeOutput(previous, *current.OfType)
+	}
+
+	// a named type whose kind changed is reported on the type itself
+	if previous.OfType == nil && current.OfType == nil {
+		return previous.Name == current.Name
+	}
+
+	if previous.Kind != current.Kind || previous.OfType == nil || current.OfType == nil {
+		return false
+	}
+
+	return safeOutput(*previous.OfType, *current.OfType)
+}
+
+// safeInput allows an input type to become nullable, everything clients send remains valid
+func safeInput(previous, current introspectionRef) bool {
+	if previous.Kind == "NON_NULL" && current.Kind != "NON_NULL" {
+		return safeInput(*previous.OfType, current)
+	}
+
+	// a named type whose kind changed is reported on the type itself
+	if previous.OfType == nil && current.OfType == nil {
+		return previous.Name == current.Name
+	}
+
+	if previous.Kind != current.Kind || previous.OfType == nil || current.OfType == nil {
+		return false
+	}
+
+	return safeInput(*previous.OfType, *current.OfType)
+}
+
+func (is *introspection) types() map[string]introspectionType {
+	types := map[string]introspectionType{}
+
+	for _, t := range is.Schema.Types {
+		types[t.Name] = t
+	}
+
+	return types
+}
+
+// sdl prints the schema with descriptions as comments, which is how
+// graphql-go parses them by default
+func (is *introspection) sdl() string {
+	var b strings.Builder
+
+	roots := []string{}
+
+	if r := is.Schema.QueryType; r != nil && r.Name != "Query" {
+		roots = append(roots, "query: "+r.Name)
+	}
+
+	if r := is.Schema.MutationType; r != nil && r.Name != "Mutation" {
+		roots = append(roots, "mutation: "+r.Name)
+	}
+
+	if r := is.Schema.SubscriptionType; r != nil && r.Name != "Subscription" {
+		roots = append(roots, "subscription: "+r.Name)
+	}
+
+	blocks := []string{}
+
+	if len(roots) > 0 {
+		blocks = append(blocks, fmt.Sprintf("schema {\n  %s\n}\n", strings.Join(roots, "\n  ")))
+	}
+
+	directives := append([]introspectionDirective{}, is.Schema.Directives...)
+
+	sort.Slice(directives, func(i, j int) bool { return directives[i].Name < directives[j].Name })
+
+	for _, d := range directives {
+		if builtinDirectives[d.Name] {
+			continue
+		}
+
+		blocks = append(blocks, fmt.Sprintf("%sdirective @%s%s on %s\n", sdlDescription(d.Description, ""), d.Name, sdlArgs(d.Args), strings.Join(d.Locations, " | ")))
+	}
+
+	types := append([]introspectionType{}, is.Schema.Types...)
+
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+	for _, t := range types {
+		if strings.HasPrefix(t.Name, "__") || builtinScalars[t.Name] {
+			continue
+		}
+
+		blocks = append(blocks, t.sdl())
+	}
+
+	b.WriteString(strings.Join(blocks, "\n"))
+
+	return b.String()
+}
+
+func (t introspectionType) sdl() string {
+	var b strings.Builder
+
+	b.WriteString(sdlDescription(t.Description, ""))
+
+	switch t.Kind {
+	case "ENUM":
+		fmt.Fprintf(&b, "enum %s {\n", t.Name)
+
+		for _, v := range t.EnumValues {
+			fmt.Fprintf(&b, "%s  %s%s\n", sdlDescription(v.Description, "  "), v.Name, sdlDeprecated(v.IsDeprecated, v.DeprecationReason))
+		}
+
+		b.WriteString("}\n")
+	case "INPUT_OBJECT":
+		fmt.Fprintf(&b, "input %s {\n", t.Name)
+
+		for _, f := range t.InputFields {
+			fmt.Fprintf(&b, "%s  %s\n", sdlDescription(f.Description, "  "), sdlInput(f))
+		}
+
+		b.WriteString("}\n")
+	case "INTERFACE", "OBJECT":
+		keyword := "type"
+
+		if t.Kind == "INTERFACE" {
+			keyword = "interface"
+		}
+
+		fmt.Fprintf(&b, "%s %s", keyword, t.Name)
+
+		if len(t.Interfaces) > 0 {
+			names := []string{}
+
+			for _, i := range t.Interfaces {
+				names = append(names, i.Name)
+			}
+
+			fmt.Fprintf(&b, " implements %s", strings.Join(names, " & "))
+		}
+
+		b.WriteString(" {\n")
+
+		for _, f := range t.Fields {
+			fmt.Fprintf(&b, "%s  %s%s: %s%s\n", sdlDescription(f.Description, "  "), f.Name, sdlArgs(f.Args), f.Type, sdlDeprecated(f.IsDeprecated, f.DeprecationReason))
+		}
+
+		b.WriteString("}\n")
+	case "SCALAR":
+		fmt.Fprintf(&b, "scalar %s\n", t.Name)
+	case "UNION":
+		names := []string{}
+
+		for _, m := range t.PossibleTypes {
+			names = append(names, m.Name)
+		}
+
+		sort.Strings(names)
+
+		fmt.Fprintf(&b, "union %s = %s\n", t.Name, strings.Join(names, " | "))
+	}
+
+	return b.String()
+}
+
+func (r introspectionRef) String() string {
+	switch r.Kind {
+	case "LIST":
+		return fmt.Sprintf("[%s]", r.OfType)
+	case "NON_NULL":
+		return fmt.Sprintf("%s!", r.OfType)
+	default:
+		return r.Name
+	}
+}
+
+func sdlArgs(args []introspectionInput) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	parts := []string{}
+
+	for _, a := range args {
+		parts = append(parts, sdlInput(a))
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(parts, ", "))
+}
+
+func sdlDeprecated(deprecated bool, reason *string) string {
+	switch {
+	case !deprecated:
+		return ""
+	case reason == nil:
+		return " @deprecated"
+	default:
+		return fmt.Sprintf(" @deprecated(reason: %s)", strconv.Quote(*reason))
+	}
+}
+
+func sdlDescription(description, indent string) string {
+	if description == "" {
+		return ""
+	}
+
+	var b strings.Builder
+
+	for _, line := range strings.Split(description, "\n") {
+		fmt.Fprintf(&b, "%s# %s\n", indent, line)
+	}
+
+	return b.String()
+}
+
+func sdlInput(i introspectionInput) string {
+	if i.DefaultValue != nil {
+		return fmt.Sprintf("%s: %s = %s", i.Name, i.Type, *i.DefaultValue)
+	}
+
+	return fmt.Sprintf("%s: %s", i.Name, i.Type)
+}
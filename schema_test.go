@@ -0,0 +1,160 @@
+package stdapp
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const breakingSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+interface Node {
+	id: ID!
+}
+
+enum Status {
+	ACTIVE
+	CLOSED
+}
+
+input Filter {
+	status: Status
+	name: String!
+}
+
+union Result = User | Post
+
+type Query {
+	node(id: ID!): Node
+	search(filter: Filter, first: Int): [Result!]!
+	user(id: ID!): User
+}
+
+type Mutation {
+	touch(id: ID!): User
+}
+
+type User implements Node {
+	id: ID!
+	name: String
+	é: String
+}
+
+type Post implements Node {
+	id: ID!
+	title: String!
+}
+
+type Comment {
+	body: String
+}
+`
+
+func TestBreakingChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace [2]string
+		changes []schemaChange
+	}{
+		{name: "unchanged"},
+		{name: "field added", replace: [2]string{"name: String\n", "name: String\n\temail: String\n"}},
+		{name: "field removed", replace: [2]string{"name: String\n", ""}, changes: []schemaChange{{Path: "User.name", Message: "field removed"}}},
+		{name: "unicode field removed", replace: [2]string{"é: String\n", ""}, changes: []schemaChange{{Path: "User.é", Message: "field removed"}}},
+		{name: "output made non-null", replace: [2]string{"name: String\n", "name: String!\n"}},
+		{name: "output made nullable", replace: [2]string{"title: String!", "title: String"}, changes: []schemaChange{{Path: "Post.title", Message: "type changed from String! to String"}}},
+		{name: "output type changed", replace: [2]string{"name: String\n", "name: Int\n"}, changes: []schemaChange{{Path: "User.name", Message: "type changed from String to Int"}}},
+		{name: "output list changed", replace: [2]string{"[Result!]!", "Result"}, changes: []schemaChange{{Path: "Query.search", Message: "type changed from [Result!]! to Result"}}},
+		{name: "argument made nullable", replace: [2]string{"user(id: ID!)", "user(id: ID)"}},
+		{name: "argument made non-null", replace: [2]string{"first: Int", "first: Int!"}, changes: []schemaChange{{Path: "Query.search.first", Message: "type changed from Int to Int!"}}},
+		{name: "argument removed", replace: [2]string{", first: Int", ""}, changes: []schemaChange{{Path: "Query.search.first", Message: "argument removed"}}},
+		{name: "optional argument added", replace: [2]string{"user(id: ID!)", "user(id: ID!, deleted: Boolean)"}},
+		{name: "required argument added", replace: [2]string{"user(id: ID!)", "user(id: ID!, deleted: Boolean!)"}, changes: []schemaChange{{Path: "Query.user.deleted", Message: "required argument added"}}},
+		{name: "required argument with default", replace: [2]string{"user(id: ID!)", "user(id: ID!, deleted: Boolean! = false)"}},
+		{name: "required input field added", replace: [2]string{"name: String!\n}", "name: String!\n\tlimit: Int!\n}"}, changes: []schemaChange{{Path: "Filter.limit", Message: "required input field added"}}},
+		{name: "input field removed", replace: [2]string{"status: Status\n", ""}, changes: []schemaChange{{Path: "Filter.status", Message: "input field removed"}}},
+		{name: "enum value added", replace: [2]string{"CLOSED\n", "CLOSED\n\tDELETED\n"}},
+		{name: "enum value removed", replace: [2]string{"CLOSED\n", ""}, changes: []schemaChange{{Path: "Status.CLOSED", Message: "enum value removed"}}},
+		{name: "union member removed", replace: [2]string{"User | Post", "User"}, changes: []schemaChange{{Path: "Result", Message: "union member Post removed"}}},
+		{name: "interface removed", replace: [2]string{"Post implements Node", "Post"}, changes: []schemaChange{{Path: "Post", Message: "no longer implements Node"}}},
+		{name: "type removed", replace: [2]string{"type Comment {\n\tbody: String\n}\n", ""}, changes: []schemaChange{{Path: "Comment", Message: "type removed"}}},
+		{name: "kind changed", replace: [2]string{"union Result = User | Post", "type Result { id: ID }"}, changes: []schemaChange{{Path: "Result", Message: "kind changed from UNION to OBJECT"}}},
+		{name: "root type removed", replace: [2]string{"\tmutation: Mutation\n", ""}, changes: []schemaChange{{Path: "schema.mutation", Message: "root type removed"}}},
+	}
+
+	previous, err := loadIntrospection([]byte(breakingSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := breakingSchema
+
+			if tt.replace[0] != "" {
+				if !strings.Contains(schema, tt.replace[0]) {
+					t.Fatalf("schema doesn't contain %q", tt.replace[0])
+				}
+
+				schema = strings.Replace(schema, tt.replace[0], tt.replace[1], 1)
+			}
+
+			current, err := loadIntrospection([]byte(schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			changes := breakingChanges(previous, current)
+
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+			expected := tt.changes
+			if expected == nil {
+				expected = []schemaChange{}
+			}
+
+			if !reflect.DeepEqual(changes, expected) {
+				t.Fatalf("expected %v, got %v", expected, changes)
+			}
+		})
+	}
+}
+
+func TestLoadIntrospection(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		query string
+		err   bool
+	}{
+		{name: "sdl", data: "type Query { a: Int }", query: "Query"},
+		{name: "json", data: `{"__schema": {"queryType": {"name": "Root"}, "types": []}}`, query: "Root"},
+		{name: "sdl byte order mark", data: "\uFEFFtype Query { a: Int }", query: "Query"},
+		{name: "json byte order mark", data: "\uFEFF" + `{"__schema": {"queryType": {"name": "Root"}, "types": []}}`, query: "Root"},
+		{name: "unicode name", data: "type Query { é: Int }", query: "Query"},
+		{name: "invalid", data: "type Query {", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is, err := loadIntrospection([]byte(tt.data))
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if is.Schema.QueryType == nil || is.Schema.QueryType.Name != tt.query {
+				t.Fatalf("expected query type %q, got %v", tt.query, is.Schema.QueryType)
+			}
+		})
+	}
+}
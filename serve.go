@@ -0,0 +1,46 @@
+package stdapp
+
+import (
+	"fmt"
+
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/stdapi"
+)
+
+type Serve struct {
+	api    *API
+	app    *App
+	server *stdapi.Server
+}
+
+func (a *App) serve() (*Serve, error) {
+	s := &Serve{
+		app:    a,
+		server: stdapi.New(a.opts.Name, a.opts.Name),
+	}
+
+	s.api = &API{
+		app:    a,
+		server: s.server,
+	}
+
+	if err := s.api.handle(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	// the api prefix itself and anything under it that was not matched above
+	// must not fall through to the spa
+	s.server.Router.Path(fmt.Sprintf("%s/api", a.opts.Prefix)).Handler(s.server.Router.NotFoundHandler)
+	s.server.Router.PathPrefix(fmt.Sprintf("%s/api/", a.opts.Prefix)).Handler(s.server.Router.NotFoundHandler)
+
+	if a.opts.Web != nil {
+		spa := &SPA{
+			app:    a,
+			server: s.server,
+		}
+
+		spa.handle()
+	}
+
+	return s, nil
+}
@@ -0,0 +1,43 @@
+package stdapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestServeAPINotFound(t *testing.T) {
+	a, err := New(Options{Web: fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := a.serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/api", status: http.StatusNotFound},
+		{path: "/api/", status: http.StatusNotFound},
+		{path: "/api/missing/thing", status: http.StatusNotFound},
+		{path: "/apis", status: http.StatusOK},
+		{path: "/dashboard", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			s.server.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
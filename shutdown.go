@@ -0,0 +1,229 @@
+package stdapp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.ddollar.dev/errors"
+)
+
+// drainer tracks hijacked websocket connections, which http.Server.Shutdown ignores
+type drainer struct {
+	conns map[*drainConn]bool
+	lock  sync.Mutex
+}
+
+func newDrainer() *drainer {
+	return &drainer{
+		conns: map[*drainConn]bool{},
+	}
+}
+
+func (d *drainer) add(c *drainConn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.conns[c] = true
+}
+
+func (d *drainer) close() {
+	d.lock.Lock()
+	conns := d.conns
+	d.conns = map[*drainConn]bool{}
+	d.lock.Unlock()
+
+	var wg sync.WaitGroup
+
+	for c := range conns {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			c.shutdown()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (d *drainer) count() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return len(d.conns)
+}
+
+func (d *drainer) remove(c *drainConn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.conns, c)
+}
+
+func (d *drainer) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hj, ok := w.(http.Hijacker); ok && websocket.IsWebSocketUpgrade(r) {
+			w = &drainWriter{ResponseWriter: w, drainer: d, hijacker: hj}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+type drainWriter struct {
+	http.ResponseWriter
+	drainer  *drainer
+	hijacker http.Hijacker
+}
+
+func (w *drainWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.hijacker.Hijack()
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	dc := &drainConn{Conn: conn, drainer: w.drainer, sent: make(chan struct{})}
+
+	w.drainer.add(dc)
+
+	return dc, rw, nil
+}
+
+// drainConn follows the frames gorilla writes so that shutdown can send its
+// close frame between them, as gorilla may split one frame across two Writes
+type drainConn struct {
+	net.Conn
+	closing   bool
+	drainer   *drainer
+	header    []byte
+	lock      sync.Mutex
+	remaining int64
+	sent      chan struct{}
+	upgraded  bool
+}
+
+func (c *drainConn) Close() error {
+	c.drainer.remove(c)
+
+	return c.Conn.Close()
+}
+
+func (c *drainConn) Write(data []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closing && c.between() {
+		return 0, net.ErrClosed
+	}
+
+	n, err := c.Conn.Write(data)
+
+	// the first write is the handshake response
+	if !c.upgraded {
+		c.upgraded = true
+		return n, err
+	}
+
+	c.track(data[:n])
+
+	if c.closing && c.between() {
+		c.close()
+	}
+
+	return n, err
+}
+
+// between reports whether the connection is not in the middle of a frame
+func (c *drainConn) between() bool {
+	return c.upgraded && c.remaining == 0 && len(c.header) == 0
+}
+
+// close writes a close frame, must be called with the lock held between frames
+func (c *drainConn) close() {
+	payload := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))               //nolint:errcheck
+	c.Conn.Write(append([]byte{0x88, byte(len(payload))}, payload...)) //nolint:errcheck
+
+	close(c.sent)
+}
+
+// shutdown sends a close frame once the frame being written is finished, then
+// closes the connection, giving up on the close frame after a second
+func (c *drainConn) shutdown() {
+	c.lock.Lock()
+
+	c.closing = true
+
+	if c.between() {
+		c.close()
+	}
+
+	c.lock.Unlock()
+
+	select {
+	case <-c.sent:
+	case <-time.After(time.Second):
+	}
+
+	c.Conn.Close()
+}
+
+// track advances through the frames in data
+func (c *drainConn) track(data []byte) {
+	for len(data) > 0 {
+		if c.remaining > 0 {
+			n := min(c.remaining, int64(len(data)))
+			c.remaining -= n
+			data = data[n:]
+			continue
+		}
+
+		c.header = append(c.header, data[0])
+		data = data[1:]
+
+		if size, ok := frameSize(c.header); ok {
+			c.header = c.header[:0]
+			c.remaining = size
+		}
+	}
+}
+
+// frameSize returns the payload length once header holds a complete frame header
+func frameSize(header []byte) (int64, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+
+	length := int64(header[1] & 0x7f)
+	offset := 2
+
+	switch length {
+	case 126:
+		offset += 2
+	case 127:
+		offset += 8
+	}
+
+	if header[1]&0x80 != 0 {
+		offset += 4
+	}
+
+	if len(header) < offset {
+		return 0, false
+	}
+
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(header[2:10]))
+	}
+
+	return length, true
+}
@@ -19,12 +19,15 @@ func (a *App) spa() (*SPA, error) {
 		server: stdapi.New(a.opts.Name, a.opts.Name),
 	}
 
-	h := http.StripPrefix(a.opts.Prefix, http.FileServer(http.FS(s)))
-
-	s.server.Router.PathPrefix(a.opts.Prefix).Handler(a.WithMiddleware(h))
+	s.handle()
 
 	return s, nil
+}
+
+func (s *SPA) handle() {
+	h := http.StripPrefix(s.app.opts.Prefix, http.FileServer(http.FS(s)))
 
+	s.server.Router.PathPrefix(s.app.opts.Prefix).Handler(s.app.WithMiddleware(h))
 }
 
 func (s SPA) Open(name string) (fs.File, error) {
@@ -2,14 +2,19 @@ package stdapp
 
 import (
 	"strings"
+	"time"
 
+	"github.com/uptrace/bun"
 	"go.ddollar.dev/logger"
+	"go.ddollar.dev/stdcli"
 )
 
 func New(opts Options) (*App, error) {
 	a := &App{
-		opts:   opts,
-		logger: logger.New("ns=stdapp"),
+		dbs:       map[string]*bun.DB{},
+		notifiers: map[string]*Notifier{},
+		opts:      opts,
+		logger:    logger.New("ns=stdapp"),
 	}
 
 	return a, nil
@@ -22,3 +27,8 @@ func parseExtensions(flag string) []string {
 
 	return strings.Split(flag, ",")
 }
+
+func flagDuration(ctx stdcli.Context, name string) time.Duration {
+	d, _ := ctx.Flags().Value(name).(time.Duration)
+	return d
+}
@@ -0,0 +1,328 @@
+// Package stdapptest runs a stdapp API in-process against throwaway database
+// schemas for integration tests.
+package stdapptest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.ddollar.dev/coalesce"
+	"go.ddollar.dev/migrate"
+	"go.ddollar.dev/stdapp"
+)
+
+const timeout = 5 * time.Second
+
+type App struct {
+	*stdapp.App
+
+	Server *httptest.Server
+
+	domains []string
+	opts    stdapp.Options
+	t       testing.TB
+}
+
+type Error struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+type Result struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []Error         `json:"errors,omitempty"`
+
+	t testing.TB
+}
+
+type Subscription struct {
+	conn *websocket.Conn
+	t    testing.TB
+}
+
+// New creates a schema for every domain, and one for the tables stdapp keeps
+// outside of domains, named with a random prefix. It runs migrations into
+// them and starts the API on an httptest.Server. Everything is torn down when
+// the test finishes. Options left empty are loaded from the environment, as
+// they are for commands, and tests are skipped when there is no database.
+func New(t testing.TB, opts stdapp.Options) *App {
+	t.Helper()
+
+	root := fmt.Sprintf("test_%s", randomID(t))
+
+	opts.RootSchema = root
+	opts.SchemaPrefix = root + "_"
+
+	sa, err := stdapp.New(opts)
+	if err != nil {
+		t.Fatalf("stdapp: %s", err)
+	}
+
+	if err := sa.LoadConfig(""); err != nil {
+		t.Fatalf("config: %s", err)
+	}
+
+	opts = sa.Options()
+
+	if opts.Database == "" {
+		t.Skip("no database configured")
+	}
+
+	a := &App{
+		App:     sa,
+		domains: coalesce.Any(opts.Domains, []string{"public"}),
+		opts:    opts,
+		t:       t,
+	}
+
+	t.Cleanup(a.cleanup)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, domain := range append([]string{""}, a.domains...) {
+		if err := a.exec(ctx, fmt.Sprintf("CREATE SCHEMA %s", quote(a.Schema(domain)))); err != nil {
+			t.Fatalf("create schema: %s", err)
+		}
+	}
+
+	if err := a.Migrate(ctx, migrate.Options{}); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+
+	h, err := a.Handler()
+	if err != nil {
+		t.Fatalf("handler: %s", err)
+	}
+
+	a.Server = httptest.NewServer(h)
+
+	return a
+}
+
+// Query runs a query or mutation against the domain's GraphQL endpoint.
+func (a *App) Query(domain, query string, variables map[string]any) *Result {
+	a.t.Helper()
+
+	data, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		a.t.Fatalf("query: %s", err)
+	}
+
+	res, err := a.Server.Client().Post(a.url("http", domain), "application/json", bytes.NewReader(data))
+	if err != nil {
+		a.t.Fatalf("query: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		a.t.Fatalf("query: unexpected status %d", res.StatusCode)
+	}
+
+	r := &Result{t: a.t}
+
+	if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+		a.t.Fatalf("query: %s", err)
+	}
+
+	return r
+}
+
+// Subscribe starts an operation over the domain's graphql-ws endpoint.
+func (a *App) Subscribe(domain, query string, variables map[string]any) *Subscription {
+	a.t.Helper()
+
+	d := websocket.Dialer{Subprotocols: []string{"graphql-ws"}, HandshakeTimeout: timeout}
+
+	conn, _, err := d.Dial(a.url("ws", domain), nil)
+	if err != nil {
+		a.t.Fatalf("subscribe: %s", err)
+	}
+
+	s := &Subscription{conn: conn, t: a.t}
+
+	a.t.Cleanup(s.Close)
+
+	s.send(map[string]any{"type": "connection_init", "payload": map[string]any{}})
+
+	if m := s.read(); m.Type != "connection_ack" {
+		a.t.Fatalf("subscribe: expected connection_ack, got %s", m.Type)
+	}
+
+	s.send(map[string]any{
+		"id":      "1",
+		"type":    "start",
+		"payload": map[string]any{"query": query, "variables": variables},
+	})
+
+	return s
+}
+
+// cleanup drops every schema the test made, including those of domains it
+// created with DynamicDomains
+func (a *App) cleanup() {
+	if a.Server != nil {
+		a.Server.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db, err := a.DB("")
+	if err != nil {
+		a.t.Errorf("drop schemas: %s", err)
+		return
+	}
+
+	schemas := []string{}
+
+	if err := db.NewRaw("SELECT nspname FROM pg_namespace WHERE nspname = ? OR left(nspname, length(?)) = ?", a.opts.RootSchema, a.opts.SchemaPrefix, a.opts.SchemaPrefix).Scan(ctx, &schemas); err != nil {
+		a.t.Errorf("drop schemas: %s", err)
+	}
+
+	for _, schema := range schemas {
+		if err := a.exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", quote(schema))); err != nil {
+			a.t.Errorf("drop schema: %s", err)
+		}
+	}
+
+	if err := a.Close(); err != nil {
+		a.t.Errorf("close: %s", err)
+	}
+}
+
+// exec runs DDL on the root database, which names its schemas in full
+func (a *App) exec(ctx context.Context, query string) error {
+	db, err := a.DB("")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, query)
+
+	return err
+}
+
+func (a *App) url(scheme, domain string) string {
+	u := strings.Replace(a.Server.URL, "http", scheme, 1)
+
+	return fmt.Sprintf("%s%s/api/%s", u, a.opts.Prefix, domain)
+}
+
+// Decode fails the test if the result has errors and otherwise decodes data into v.
+func (r *Result) Decode(v any) {
+	r.t.Helper()
+
+	r.NoErrors()
+
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		r.t.Fatalf("decode: %s", err)
+	}
+}
+
+// NoErrors fails the test if the result has errors.
+func (r *Result) NoErrors() {
+	r.t.Helper()
+
+	for _, e := range r.Errors {
+		r.t.Errorf("graphql: %s %v", e.Message, e.Path)
+	}
+
+	if len(r.Errors) > 0 {
+		r.t.FailNow()
+	}
+}
+
+// Next waits for the next result, returning nil once the operation completes.
+func (s *Subscription) Next() *Result {
+	s.t.Helper()
+
+	for {
+		m := s.read()
+
+		switch m.Type {
+		case "complete":
+			return nil
+		case "data":
+			r := &Result{t: s.t}
+
+			if err := json.Unmarshal(m.Payload, r); err != nil {
+				s.t.Fatalf("subscription: %s", err)
+			}
+
+			return r
+		case "error", "connection_error":
+			r := &Result{t: s.t}
+
+			var e Error
+
+			if err := json.Unmarshal(m.Payload, &e); err != nil {
+				s.t.Fatalf("subscription: %s", err)
+			}
+
+			r.Errors = append(r.Errors, e)
+
+			return r
+		}
+	}
+}
+
+func (s *Subscription) Close() {
+	s.conn.WriteJSON(map[string]any{"type": "connection_terminate"}) //nolint:errcheck
+	s.conn.Close()
+}
+
+type message struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+	Type    string          `json:"type"`
+}
+
+func (s *Subscription) read() message {
+	s.t.Helper()
+
+	var m message
+
+	if err := s.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		s.t.Fatalf("subscription: %s", err)
+	}
+
+	if err := s.conn.ReadJSON(&m); err != nil {
+		s.t.Fatalf("subscription: %s", err)
+	}
+
+	return m
+}
+
+func (s *Subscription) send(v any) {
+	s.t.Helper()
+
+	if err := s.conn.WriteJSON(v); err != nil {
+		s.t.Fatalf("subscription: %s", err)
+	}
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func randomID(t testing.TB) string {
+	data := make([]byte, 6)
+
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("random: %s", err)
+	}
+
+	return hex.EncodeToString(data)
+}
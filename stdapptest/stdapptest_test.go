@@ -0,0 +1,132 @@
+package stdapptest_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/uptrace/bun"
+	"go.ddollar.dev/stdapp"
+	"go.ddollar.dev/stdapp/stdapptest"
+)
+
+const schema = `
+schema {
+	query: Query
+	subscription: Subscription
+}
+
+type Query {
+	messages: [String!]!
+}
+
+type Subscription {
+	count(to: Int!): Int!
+}
+`
+
+type resolver struct {
+	db *bun.DB
+}
+
+func (r *resolver) Mutation() any     { return &struct{}{} }
+func (r *resolver) Query() any        { return &query{r: r} }
+func (r *resolver) Schema() string    { return schema }
+func (r *resolver) Subscription() any { return &subscription{} }
+
+type query struct {
+	r *resolver
+}
+
+func (q *query) Messages(ctx context.Context) ([]string, error) {
+	messages := []string{}
+
+	if err := q.r.db.NewRaw("SELECT body FROM messages ORDER BY id").Scan(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+type subscription struct{}
+
+func (s *subscription) Count(ctx context.Context, args struct{ To int32 }) (<-chan int32, error) {
+	ch := make(chan int32)
+
+	go func() {
+		defer close(ch)
+
+		for i := int32(1); i <= args.To; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func TestApp(t *testing.T) {
+	a := stdapptest.New(t, stdapp.Options{
+		Domains: []string{"acme", "globex"},
+		Migrations: fstest.MapFS{
+			"db/migrate/acme/20240101000000_messages.sql":   {Data: []byte("CREATE TABLE messages (id serial PRIMARY KEY, body text NOT NULL); INSERT INTO messages (body) VALUES ('hello');")},
+			"db/migrate/globex/20240101000000_messages.sql": {Data: []byte("CREATE TABLE messages (id serial PRIMARY KEY, body text NOT NULL);")},
+		},
+		Resolver: func(db *bun.DB, domain string) (stdapp.Resolver, error) {
+			return &resolver{db: db}, nil
+		},
+	})
+
+	t.Run("query", func(t *testing.T) {
+		tests := []struct {
+			domain   string
+			messages []string
+		}{
+			{domain: "acme", messages: []string{"hello"}},
+			{domain: "globex", messages: []string{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.domain, func(t *testing.T) {
+				var data struct {
+					Messages []string `json:"messages"`
+				}
+
+				a.Query(tt.domain, "{ messages }", nil).Decode(&data)
+
+				if !reflect.DeepEqual(data.Messages, tt.messages) {
+					t.Fatalf("expected %q, got %q", tt.messages, data.Messages)
+				}
+			})
+		}
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		s := a.Subscribe("acme", "subscription($to: Int!) { count(to: $to) }", map[string]any{"to": 3})
+
+		for i := 1; i <= 3; i++ {
+			var data struct {
+				Count int `json:"count"`
+			}
+
+			r := s.Next()
+			if r == nil {
+				t.Fatalf("expected %d, got complete", i)
+			}
+
+			r.Decode(&data)
+
+			if data.Count != i {
+				t.Fatalf("expected %d, got %d", i, data.Count)
+			}
+		}
+
+		if r := s.Next(); r != nil {
+			t.Fatalf("expected complete, got %s", r.Data)
+		}
+	})
+}
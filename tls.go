@@ -0,0 +1,227 @@
+package stdapp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.ddollar.dev/errors"
+	"go.ddollar.dev/logger"
+)
+
+func (a *App) tlsConfig(ctx context.Context, hostname string, opts listenOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.Protocol == "h2" {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	switch {
+	case opts.CertificateFile != "" || opts.KeyFile != "":
+		if opts.CertificateFile == "" || opts.KeyFile == "" {
+			return nil, errors.Errorf("certificate and key must be specified together")
+		}
+
+		cr, err := newCertificateReloader(ctx, opts.CertificateFile, opts.KeyFile, a.logger.At("certificate"))
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		config.GetCertificate = cr.certificate
+	default:
+		cert, err := developmentCertificate(hostname, opts.CertificateCache)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+type certificateReloader struct {
+	cert     *tls.Certificate
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	logger   *logger.Logger
+}
+
+func newCertificateReloader(ctx context.Context, certFile, keyFile string, log *logger.Logger) (*certificateReloader, error) {
+	cr := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   log,
+	}
+
+	if err := cr.load(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	// watch the directories so that atomically replaced files (such as kubernetes secrets) are seen
+	dirs := map[string]bool{filepath.Dir(certFile): true, filepath.Dir(keyFile): true}
+
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	go cr.watch(ctx, w)
+
+	return cr, nil
+}
+
+func (cr *certificateReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	return cr.cert, nil
+}
+
+func (cr *certificateReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	cr.lock.Lock()
+	cr.cert = &cert
+	cr.lock.Unlock()
+
+	cr.logger.Logf("cert=%q key=%q state=loaded", cr.certFile, cr.keyFile)
+
+	return nil
+}
+
+func (cr *certificateReloader) watch(ctx context.Context, w *fsnotify.Watcher) {
+	defer w.Close()
+
+	t := time.NewTimer(1 * time.Hour)
+
+	if !t.Stop() {
+		<-t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.Events:
+			if e.Op.Has(fsnotify.Chmod) {
+				continue
+			}
+
+			t.Reset(debounce)
+		case err := <-w.Errors:
+			cr.logger.Error(err) //nolint:errcheck
+		case <-t.C:
+			if err := cr.load(); err != nil {
+				cr.logger.Error(err) //nolint:errcheck
+			}
+		}
+	}
+}
+
+func developmentCertificate(host, cache string) (tls.Certificate, error) {
+	if cache == "" {
+		pub, key, err := generateCertificate(host)
+		if err != nil {
+			return tls.Certificate{}, errors.Wrap(err)
+		}
+
+		return keyPair(pub, key)
+	}
+
+	certFile := filepath.Join(cache, host+".crt")
+	keyFile := filepath.Join(cache, host+".key")
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Now().Add(24*time.Hour).Before(leaf.NotAfter) {
+			return cert, nil
+		}
+	}
+
+	pub, key, err := generateCertificate(host)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err)
+	}
+
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return tls.Certificate{}, errors.Wrap(err)
+	}
+
+	if err := os.WriteFile(certFile, pub, 0644); err != nil {
+		return tls.Certificate{}, errors.Wrap(err)
+	}
+
+	if err := os.WriteFile(keyFile, key, 0600); err != nil {
+		return tls.Certificate{}, errors.Wrap(err)
+	}
+
+	return keyPair(pub, key)
+}
+
+func generateCertificate(host string) ([]byte, []byte, error) {
+	rkey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   host,
+			Organization: []string{"secure"},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{host},
+	}
+
+	data, err := x509.CreateCertificate(rand.Reader, &template, &template, &rkey.PublicKey, rkey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	pub := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: data})
+	key := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rkey)})
+
+	return pub, key, nil
+}
+
+func keyPair(pub, key []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(pub, key)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err)
+	}
+
+	return cert, nil
+}
@@ -21,6 +21,7 @@ func (a *App) spawn(command string, args ...string) (*exec.Cmd, error) {
 		Setpgid: true,
 	}
 
+	cmd.Env = append(os.Environ(), "DEVELOPMENT=true")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 